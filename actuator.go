@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// actuator drives roof motors and relays. Commands are generic names such as
+// "open", "close" and "stop", translated by each backend. status returns the
+// backend's view of the roof ("open", "closed", "opening", "closing" or
+// "error"), or "" when it has no feedback.
+type actuator interface {
+	command(name string) error
+	status() (string, error)
+}
+
+// ActuatorConfig selects and configures an actuator backend
+type ActuatorConfig struct {
+	Type string `json:"type"` // commandfile, http, gpio or simulator
+
+	// Commands maps command names to what the backend sends: the text
+	// written to CommandFile, a URL, or GPIO writes such as "17=1,27=0"
+	Commands map[string]string `json:"commands"`
+
+	// commandfile: the file SkyRoof watches for commands and the file it
+	// writes the roof state to
+	CommandFile string `json:"commandFile"`
+	StatusFile  string `json:"statusFile"`
+
+	// http: request method for command URLs and an optional state URL
+	Method    string `json:"method"`
+	StatusURL string `json:"statusUrl"`
+
+	// gpio: sysfs root, input pins reading 1 at each limit ("open",
+	// "closed"), and how long outputs are held before being reset
+	GPIOPath    string         `json:"gpioPath"`
+	StatusPins  map[string]int `json:"statusPins"`
+	PulseLength string         `json:"pulseLength"`
+
+	// Time taken to open or close. The simulator moves for this long, and
+	// a roof without feedback is taken to have arrived after it.
+	TravelTime string `json:"travelTime"`
+}
+
+func validateActuatorConfig(name string, a *ActuatorConfig, required []string) error {
+	switch a.Type {
+	case "commandfile":
+		if a.CommandFile == "" {
+			return fmt.Errorf("%s actuator needs a commandFile", name)
+		}
+		if a.Commands == nil {
+			a.Commands = map[string]string{"open": "OPEN", "close": "CLOSE", "stop": "STOP"}
+		}
+	case "http":
+		if a.Method == "" {
+			a.Method = http.MethodGet
+		}
+	case "gpio":
+		if a.GPIOPath == "" {
+			a.GPIOPath = "/sys/class/gpio"
+		}
+		for command, writes := range a.Commands {
+			if _, err := parseGPIOWrites(writes); err != nil {
+				return fmt.Errorf("%s actuator command %q: %v", name, command, err)
+			}
+		}
+		if a.PulseLength != "" {
+			if _, err := time.ParseDuration(a.PulseLength); err != nil {
+				return fmt.Errorf("invalid pulseLength for %s actuator: %q", name, a.PulseLength)
+			}
+		}
+	case "simulator":
+	default:
+		return fmt.Errorf("%s actuator has unknown type %q", name, a.Type)
+	}
+
+	if a.TravelTime == "" {
+		a.TravelTime = "30s"
+	}
+	if _, err := time.ParseDuration(a.TravelTime); err != nil {
+		return fmt.Errorf("invalid travelTime for %s actuator: %q", name, a.TravelTime)
+	}
+	if a.Type == "simulator" {
+		return nil
+	}
+	for _, command := range required {
+		if a.Commands[command] == "" {
+			return fmt.Errorf("%s actuator has no %q command", name, command)
+		}
+	}
+	return nil
+}
+
+func newActuator(a ActuatorConfig) actuator {
+	switch a.Type {
+	case "commandfile":
+		return &commandFileActuator{config: a}
+	case "http":
+		return &httpActuator{config: a}
+	case "gpio":
+		return &gpioActuator{config: a}
+	default:
+		travelTime, _ := time.ParseDuration(a.TravelTime)
+		return &simulatedActuator{travelTime: travelTime, state: "closed", since: time.Now()}
+	}
+}
+
+// parseActuatorStatus recognises a roof state in free text such as the
+// contents of a status file
+func parseActuatorStatus(text string) string {
+	text = strings.ToLower(text)
+	for _, state := range []string{"opening", "closing", "closed", "open", "error"} {
+		if strings.Contains(text, state) {
+			return state
+		}
+	}
+	return ""
+}
+
+func actuatorCommand(commands map[string]string, name string) (string, error) {
+	value, ok := commands[name]
+	if !ok {
+		return "", fmt.Errorf("actuator has no %q command", name)
+	}
+	return value, nil
+}
+
+// commandFileActuator speaks the command file protocol of SkyRoof: a command
+// word is written to the command file and the roof state read back from the
+// status file
+type commandFileActuator struct {
+	config ActuatorConfig
+}
+
+func (c *commandFileActuator) command(name string) error {
+	text, err := actuatorCommand(c.config.Commands, name)
+	if err != nil {
+		return err
+	}
+	tmp := c.config.CommandFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(text+"\r\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.config.CommandFile)
+}
+
+func (c *commandFileActuator) status() (string, error) {
+	if c.config.StatusFile == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(c.config.StatusFile)
+	if err != nil {
+		return "", err
+	}
+	return parseActuatorStatus(string(data)), nil
+}
+
+// httpActuator requests a URL per command, as relay boards expect
+type httpActuator struct {
+	config ActuatorConfig
+}
+
+func (h *httpActuator) request(method, url string) (string, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return "", err
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("relay returned %s", resp.Status)
+	}
+	return string(body), nil
+}
+
+func (h *httpActuator) command(name string) error {
+	url, err := actuatorCommand(h.config.Commands, name)
+	if err != nil {
+		return err
+	}
+	_, err = h.request(h.config.Method, url)
+	return err
+}
+
+func (h *httpActuator) status() (string, error) {
+	if h.config.StatusURL == "" {
+		return "", nil
+	}
+	body, err := h.request(http.MethodGet, h.config.StatusURL)
+	if err != nil {
+		return "", err
+	}
+	return parseActuatorStatus(body), nil
+}
+
+// gpioActuator drives relays through the Linux sysfs GPIO interface
+type gpioActuator struct {
+	config   ActuatorConfig
+	exported sync.Map
+}
+
+type gpioWrite struct {
+	Pin   int
+	Value int
+}
+
+// parseGPIOWrites parses "pin=value" pairs separated by commas
+func parseGPIOWrites(text string) ([]gpioWrite, error) {
+	var writes []gpioWrite
+	for _, pair := range strings.Split(text, ",") {
+		pin, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		p, err1 := strconv.Atoi(pin)
+		v, err2 := strconv.Atoi(value)
+		if !ok || err1 != nil || err2 != nil || p < 0 || (v != 0 && v != 1) {
+			return nil, fmt.Errorf("expected pin=0|1, got %q", pair)
+		}
+		writes = append(writes, gpioWrite{p, v})
+	}
+	return writes, nil
+}
+
+// export makes a pin available with the given direction ("in" or "out")
+func (g *gpioActuator) export(pin int, direction string) error {
+	if _, ok := g.exported.Load(pin); ok {
+		return nil
+	}
+	dir := filepath.Join(g.config.GPIOPath, fmt.Sprintf("gpio%d", pin))
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.WriteFile(filepath.Join(g.config.GPIOPath, "export"), []byte(strconv.Itoa(pin)), 0644); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "direction"), []byte(direction), 0644); err != nil {
+		return err
+	}
+	g.exported.Store(pin, true)
+	return nil
+}
+
+func (g *gpioActuator) write(writes []gpioWrite, invert bool) error {
+	for _, w := range writes {
+		if err := g.export(w.Pin, "out"); err != nil {
+			return err
+		}
+		value := w.Value
+		if invert {
+			value = 1 - value
+		}
+		path := filepath.Join(g.config.GPIOPath, fmt.Sprintf("gpio%d", w.Pin), "value")
+		if err := os.WriteFile(path, []byte(strconv.Itoa(value)), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *gpioActuator) command(name string) error {
+	text, err := actuatorCommand(g.config.Commands, name)
+	if err != nil {
+		return err
+	}
+	writes, _ := parseGPIOWrites(text)
+	if err := g.write(writes, false); err != nil {
+		return err
+	}
+	if g.config.PulseLength != "" {
+		pulse, _ := time.ParseDuration(g.config.PulseLength)
+		time.Sleep(pulse)
+		return g.write(writes, true)
+	}
+	return nil
+}
+
+// read reports whether an input pin is high
+func (g *gpioActuator) read(pin int) (bool, error) {
+	if err := g.export(pin, "in"); err != nil {
+		return false, err
+	}
+	value, err := os.ReadFile(filepath.Join(g.config.GPIOPath, fmt.Sprintf("gpio%d", pin), "value"))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(value)) == "1", nil
+}
+
+func (g *gpioActuator) status() (string, error) {
+	for _, state := range []string{"open", "closed"} {
+		pin, ok := g.config.StatusPins[state]
+		if !ok {
+			continue
+		}
+		high, err := g.read(pin)
+		if err != nil {
+			return "", err
+		}
+		if high {
+			return state, nil
+		}
+	}
+	return "", nil
+}
+
+// simulatedActuator is an in-process roof taking travelTime to move, or an
+// output switched "on" and "off"
+type simulatedActuator struct {
+	mu         sync.Mutex
+	travelTime time.Duration
+	state      string
+	since      time.Time
+}
+
+func (s *simulatedActuator) command(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.settle()
+	switch name {
+	case "open":
+		if s.state != "open" {
+			s.state, s.since = "opening", time.Now()
+		}
+	case "close":
+		if s.state != "closed" {
+			s.state, s.since = "closing", time.Now()
+		}
+	case "stop":
+		if s.state == "opening" || s.state == "closing" {
+			s.state = "error"
+		}
+	case "on", "off":
+		s.state = name
+	default:
+		return fmt.Errorf("actuator has no %q command", name)
+	}
+	return nil
+}
+
+func (s *simulatedActuator) status() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settle()
+	return s.state, nil
+}
+
+// settle completes a movement once the travel time has passed
+func (s *simulatedActuator) settle() {
+	if time.Since(s.since) < s.travelTime {
+		return
+	}
+	switch s.state {
+	case "opening":
+		s.state = "open"
+	case "closing":
+		s.state = "closed"
+	}
+}
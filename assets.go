@@ -0,0 +1,23 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// Dashboard scripts and styles are compiled into the binary so the web
+// pages work on an observatory network with no internet access
+//
+//go:embed static
+var staticFiles embed.FS
+
+// HTML page templates
+//
+//go:embed templates
+var templateFiles embed.FS
+
+func staticHandler() http.Handler {
+	files, _ := fs.Sub(staticFiles, "static")
+	return http.StripPrefix("/static/", http.FileServer(http.FS(files)))
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ParkInterlockConfig describes a "mount parked" input that must confirm
+// before the roof is closed automatically. It is disabled when Type is empty.
+type ParkInterlockConfig struct {
+	Type string `json:"type"` // file, http or gpio
+
+	// file: path whose contents read "1", "true", "yes" or "parked" when parked
+	Path string `json:"path"`
+
+	// http: URL returning the same words, or Alpaca JSON such as the
+	// telescope's atpark property
+	URL string `json:"url"`
+
+	// gpio: input pin reading 1 when parked
+	Pin      int    `json:"pin"`
+	GPIOPath string `json:"gpioPath"`
+
+	// Optional URL sent a PUT to request parking, such as an Alpaca
+	// telescope's park method
+	ParkURL string `json:"parkUrl"`
+
+	// How long to wait for the mount to park before closing anyway, 10m by
+	// default so a stuck mount cannot keep the roof open in the rain
+	Timeout string `json:"timeout"`
+}
+
+var autoCloseCheckInterval = 5 * time.Second
+
+func validateParkInterlock() error {
+	p := &config.Dome.ParkInterlock
+	switch p.Type {
+	case "":
+		return nil
+	case "file":
+		if p.Path == "" {
+			return fmt.Errorf("Dome.ParkInterlock needs a path")
+		}
+	case "http":
+		if p.URL == "" {
+			return fmt.Errorf("Dome.ParkInterlock needs a url")
+		}
+	case "gpio":
+		if p.GPIOPath == "" {
+			p.GPIOPath = "/sys/class/gpio"
+		}
+	default:
+		return fmt.Errorf("Dome.ParkInterlock has unknown type %q", p.Type)
+	}
+	if p.Timeout == "" {
+		p.Timeout = "10m"
+	}
+	timeout, err := time.ParseDuration(p.Timeout)
+	if err != nil || timeout <= 0 {
+		return fmt.Errorf("invalid Dome.ParkInterlock.Timeout in config file: %q", p.Timeout)
+	}
+	return nil
+}
+
+// startAutoClose closes the roof whenever conditions turn unsafe
+func startAutoClose() {
+	if dome == nil || !config.Dome.AutoClose {
+		return
+	}
+	unsafe := make(chan struct{}, 1)
+	onSafetyChange(func(verdict SafetyVerdict) {
+		if verdict.Safe {
+			return
+		}
+		select {
+		case unsafe <- struct{}{}:
+		default:
+		}
+	})
+	go watchAutoClose(unsafe)
+}
+
+// watchAutoClose acts on each unsafe verdict, and rechecks periodically in
+// case the roof is opened locally while conditions are unsafe
+func watchAutoClose(unsafe chan struct{}) {
+	ticker := time.NewTicker(autoCloseCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-unsafe:
+		case <-ticker.C:
+		}
+		if verdict := currentSafety(); !verdict.Safe {
+			autoClose(verdict)
+		}
+	}
+}
+
+func autoClose(verdict SafetyVerdict) {
+	state, err := dome.shutterStatus()
+	if err != nil {
+		log.Printf("Auto-close: roof state unknown (%v), closing anyway", err)
+	} else if state == shutterClosed || state == shutterClosing {
+		return
+	}
+
+	reasons := strings.Join(verdict.Reasons, "; ")
+	log.Printf("Auto-close: conditions unsafe (%s), roof is %s", reasons, shutterStateNames[state])
+
+	if config.Dome.ParkInterlock.Type != "" && !waitForPark() {
+		return
+	}
+	if err := dome.move(shutterClosed, "auto-close: "+reasons); err != nil {
+		log.Printf("Auto-close: closing the roof failed: %v", err)
+	}
+}
+
+// waitForPark blocks until the mount reports parked or the interlock timeout
+// passes. It returns false if conditions become safe again first.
+func waitForPark() bool {
+	interlock := config.Dome.ParkInterlock
+	if parked, err := mountParked(); err == nil && parked {
+		log.Printf("Auto-close: mount is parked")
+		return true
+	}
+
+	if interlock.ParkURL != "" {
+		log.Printf("Auto-close: requesting mount park")
+		if err := requestPark(interlock.ParkURL); err != nil {
+			log.Printf("Auto-close: park request failed: %v", err)
+		}
+	}
+
+	timeout, _ := time.ParseDuration(interlock.Timeout)
+	deadline := time.After(timeout)
+	log.Printf("Auto-close: waiting for the mount to park before closing")
+
+	ticker := time.NewTicker(autoCloseCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-deadline:
+			log.Printf("Auto-close: mount not parked after %s, closing anyway", interlock.Timeout)
+			return true
+		case <-ticker.C:
+		}
+
+		parked, err := mountParked()
+		if err != nil {
+			log.Printf("Auto-close: reading park interlock failed: %v", err)
+		} else if parked {
+			log.Printf("Auto-close: mount is parked")
+			return true
+		}
+		if currentSafety().Safe {
+			log.Printf("Auto-close: conditions safe again, not closing")
+			return false
+		}
+	}
+}
+
+// mountParked reads the park interlock input
+func mountParked() (bool, error) {
+	interlock := config.Dome.ParkInterlock
+	switch interlock.Type {
+	case "file":
+		data, err := os.ReadFile(interlock.Path)
+		if err != nil {
+			return false, err
+		}
+		return parkedText(string(data)), nil
+	case "http":
+		client := http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Get(interlock.URL)
+		if err != nil {
+			return false, err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if resp.StatusCode/100 != 2 {
+			return false, fmt.Errorf("park interlock returned %s", resp.Status)
+		}
+		var alpaca struct {
+			Value *bool `json:"Value"`
+		}
+		if json.Unmarshal(body, &alpaca) == nil && alpaca.Value != nil {
+			return *alpaca.Value, nil
+		}
+		return parkedText(string(body)), nil
+	case "gpio":
+		input := &gpioActuator{config: ActuatorConfig{GPIOPath: interlock.GPIOPath}}
+		return input.read(interlock.Pin)
+	}
+	return true, nil
+}
+
+func parkedText(text string) bool {
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "1", "true", "yes", "parked":
+		return true
+	}
+	return false
+}
+
+func requestPark(url string) error {
+	req, err := http.NewRequest(http.MethodPut, url, strings.NewReader("ClientTransactionID=0"))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("park request returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupAutoClose opens a simulated roof under rain, with the park interlock
+// read from a file holding parked
+func setupAutoClose(t *testing.T, parked string, timeout string) (*simulatedActuator, string) {
+	t.Helper()
+	saved := autoCloseCheckInterval
+	autoCloseCheckInterval = 5 * time.Millisecond
+	t.Cleanup(func() {
+		autoCloseCheckInterval = saved
+		dome = nil
+	})
+
+	interlock := filepath.Join(t.TempDir(), "parked")
+	if err := os.WriteFile(interlock, []byte(parked), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setTestWeatherData(t, []SourceConfig{{Name: "roof", Type: "boltwood"}}, WeatherData{})
+	setAutoCloseRain(1)
+	config.Safety = SafetyConfig{UnsafeConditions: map[string][]string{}, UnsafeFlags: []string{"rainFlag"}, MaxDataAge: "5m"}
+	config.Dome = DomeConfig{AutoClose: true}
+	if timeout != "" {
+		config.Dome.ParkInterlock = ParkInterlockConfig{Type: "file", Path: interlock, Timeout: timeout}
+	}
+
+	actuator := &simulatedActuator{travelTime: time.Hour, state: "open", since: time.Now()}
+	dome = &rollOffRoof{actuator: actuator, travelTime: time.Hour, moveTimeout: time.Hour, state: shutterOpen, connected: true}
+	return actuator, interlock
+}
+
+// setAutoCloseRain replaces the weather data, raining when rainFlag is 1
+func setAutoCloseRain(rainFlag int) {
+	now := time.Now()
+	weatherMutex.Lock()
+	weatherData = WeatherData{Date: now, RainFlag: rainFlag, Sensors: map[string]SensorInfo{"rainFlag": {Source: "roof", Updated: now}}}
+	weatherMutex.Unlock()
+}
+
+func TestAutoCloseWhenUnsafe(t *testing.T) {
+	tests := []struct {
+		name    string
+		parked  string
+		timeout string
+	}{
+		{"without an interlock", "", ""},
+		{"mount already parked", "parked\n", "1h"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actuator, _ := setupAutoClose(t, tt.parked, tt.timeout)
+			verdict := currentSafety()
+			if verdict.Safe {
+				t.Fatal("rain is safe")
+			}
+			autoClose(verdict)
+			if dome.state != shutterClosing || actuator.state != "closing" {
+				t.Errorf("roof %s, actuator %q; want closing", shutterStateNames[dome.state], actuator.state)
+			}
+		})
+	}
+}
+
+func TestAutoCloseWaitsForPark(t *testing.T) {
+	// The mount parks while the roof waits
+	actuator, interlock := setupAutoClose(t, "0", "1h")
+	done := make(chan struct{})
+	go func() {
+		autoClose(currentSafety())
+		close(done)
+	}()
+	time.Sleep(30 * time.Millisecond)
+	if state, _ := actuator.status(); state != "open" {
+		t.Fatalf("roof %s before the mount parked", state)
+	}
+	os.WriteFile(interlock, []byte("true"), 0644)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("auto-close still waiting after the mount parked")
+	}
+	if actuator.state != "closing" {
+		t.Errorf("actuator %q after the mount parked, want closing", actuator.state)
+	}
+}
+
+func TestAutoCloseTimesOut(t *testing.T) {
+	actuator, _ := setupAutoClose(t, "0", "50ms")
+	start := time.Now()
+	autoClose(currentSafety())
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("closed after %s without waiting for the mount", waited)
+	}
+	if actuator.state != "closing" {
+		t.Errorf("actuator %q after the interlock timeout, want closing anyway", actuator.state)
+	}
+}
+
+func TestAutoCloseSafeAgain(t *testing.T) {
+	actuator, _ := setupAutoClose(t, "0", "1h")
+	done := make(chan struct{})
+	go func() {
+		autoClose(currentSafety())
+		close(done)
+	}()
+	time.Sleep(30 * time.Millisecond)
+	setAutoCloseRain(0)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("auto-close still waiting after conditions became safe")
+	}
+	if actuator.state != "open" || dome.state != shutterOpen {
+		t.Errorf("roof %s, actuator %q after conditions became safe; want open", shutterStateNames[dome.state], actuator.state)
+	}
+}
+
+func TestOpenShutterRefusedWhileUnsafe(t *testing.T) {
+	actuator, _ := setupAutoClose(t, "", "")
+	actuator.state, dome.state = "closed", shutterClosed
+
+	openShutter := func() int {
+		form := url.Values{"ClientID": {"1"}, "ClientTransactionID": {"1"}}
+		r := httptest.NewRequest(http.MethodPut, "/api/v1/dome/0/openshutter", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handleOpenShutter(w, r)
+		var response struct{ ErrorNumber int }
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return response.ErrorNumber
+	}
+
+	if errorNumber := openShutter(); errorNumber != errInvalidOperation || actuator.state != "closed" {
+		t.Errorf("open while raining: ErrorNumber 0x%X, actuator %q", errorNumber, actuator.state)
+	}
+	setAutoCloseRain(0)
+	if errorNumber := openShutter(); errorNumber != 0 || actuator.state != "opening" {
+		t.Errorf("open while dry: ErrorNumber 0x%X, actuator %q", errorNumber, actuator.state)
+	}
+}
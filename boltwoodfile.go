@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// BoltwoodFileConfig enables writing a Boltwood II one-line data file for
+// legacy consumers such as ACP, CCDAutoPilot and roof controllers
+type BoltwoodFileConfig struct {
+	Path string `json:"path"`
+
+	// By default the roof close flag is also set while the safety verdict is
+	// unsafe; with RoofCloseFromSource only the source's own flag is passed on
+	RoofCloseFromSource bool `json:"roofCloseFromSource"`
+}
+
+// initBoltwoodFile rewrites the data file after every update and safety change
+func initBoltwoodFile() {
+	if config.BoltwoodFile.Path == "" {
+		return
+	}
+
+	updates := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case updates <- struct{}{}:
+		default:
+		}
+	}
+	onWeatherUpdate(func(WeatherData) { notify() })
+	onSafetyChange(func(SafetyVerdict) { notify() })
+
+	go func() {
+		for range updates {
+			if err := writeBoltwoodFile(config.BoltwoodFile.Path); err != nil {
+				log.Printf("Error writing Boltwood data file: %v", err)
+			}
+		}
+	}()
+}
+
+// writeBoltwoodFile writes the current data atomically, so readers never see
+// a partial line
+func writeBoltwoodFile(path string) error {
+	data := getWeatherData()
+	if data.Date.IsZero() {
+		return nil
+	}
+	if !config.BoltwoodFile.RoofCloseFromSource && !currentSafety().Safe {
+		data.RoofCloseFlag = 1
+	}
+	since := int(time.Since(data.Date).Seconds())
+	line := formatBoltwoodLine(data, max(since, 0)) + "\r\n"
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(line); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteBoltwoodFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "boltwood.txt")
+	updated := time.Now().Add(-10 * time.Second)
+	data := WeatherData{
+		Date:           updated,
+		SkyTemperature: -21.5,
+		CloudCondition: "Clear",
+		Sensors:        map[string]SensorInfo{"rainFlag": {Source: "roof", Updated: updated}},
+	}
+
+	// readFlags returns the roof close flag and the whole line
+	readFlags := func() (string, string) {
+		t.Helper()
+		if err := writeBoltwoodFile(path); err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		line := string(content)
+		if !strings.HasSuffix(line, "\r\n") || strings.Count(line, "\n") != 1 {
+			t.Fatalf("not one CRLF terminated line: %q", line)
+		}
+		parsed, err := parseBoltwoodData(content)
+		if err != nil {
+			t.Fatalf("written line does not parse: %v", err)
+		}
+		if parsed.SkyTemperature != -21.5 || parsed.CloudCondition != "Clear" {
+			t.Errorf("written line %q lost readings", line)
+		}
+		fields := strings.Fields(line)
+		return fields[19], line
+	}
+
+	setTestWeatherData(t, nil, data)
+	config.Safety = SafetyConfig{UnsafeConditions: map[string][]string{}, UnsafeFlags: []string{"rainFlag"}, MaxDataAge: "5m"}
+	if flag, line := readFlags(); flag != "0" {
+		t.Errorf("roof close flag set while safe: %q", line)
+	}
+
+	// Unsafe conditions close the roof
+	data.RainFlag = 1
+	setTestWeatherData(t, nil, data)
+	config.Safety = SafetyConfig{UnsafeConditions: map[string][]string{}, UnsafeFlags: []string{"rainFlag"}, MaxDataAge: "5m"}
+	if flag, line := readFlags(); flag != "1" {
+		t.Errorf("roof close flag clear while unsafe: %q", line)
+	}
+
+	// unless only the source's own flag is wanted
+	config.BoltwoodFile.RoofCloseFromSource = true
+	if flag, line := readFlags(); flag != "0" {
+		t.Errorf("roof close flag set without the source setting it: %q", line)
+	}
+
+	// No temporary files are left behind
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("%d files in the directory, want only the data file", len(entries))
+	}
+}
+
+func TestWriteBoltwoodFileWithoutData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "boltwood.txt")
+	setTestWeatherData(t, nil, WeatherData{})
+	if err := writeBoltwoodFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file written before any data was received: %v", err)
+	}
+}
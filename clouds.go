@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// CloudCoverConfig configures the cloud cover estimate. The infrared sky
+// temperature is corrected with the AAG CloudWatcher model, whose K1 to K7
+// coefficients give the clear-sky baseline for the ambient temperature, and
+// the corrected temperature is scaled between the clear and overcast limits.
+type CloudCoverConfig struct {
+	Coefficients        []float64 `json:"coefficients"`
+	ClearTemperature    *float64  `json:"clearTemperature"`
+	OvercastTemperature *float64  `json:"overcastTemperature"`
+}
+
+// AAG CloudWatcher default coefficients K1 to K7
+var defaultCloudCoefficients = []float64{33, 0, 4, 100, 100, 0, 0}
+
+// Ambient temperature span (°C) clear nights must cover to fit K3
+const minExponentialFitRange = 15
+
+func validateCloudCoverConfig() error {
+	c := &config.CloudCover
+	if c.Coefficients == nil {
+		c.Coefficients = defaultCloudCoefficients
+	}
+	if len(c.Coefficients) != 7 {
+		return fmt.Errorf("CloudCover.Coefficients needs the 7 values K1 to K7, got %d", len(c.Coefficients))
+	}
+	if c.ClearTemperature == nil {
+		clear := -15.0
+		c.ClearTemperature = &clear
+	}
+	if c.OvercastTemperature == nil {
+		overcast := 0.0
+		c.OvercastTemperature = &overcast
+	}
+	if *c.OvercastTemperature <= *c.ClearTemperature {
+		return fmt.Errorf("CloudCover.OvercastTemperature must be above ClearTemperature")
+	}
+	return nil
+}
+
+// skyTemperatureCorrection is the AAG model's expected clear-sky difference
+// for an ambient temperature, subtracted from the measured sky temperature
+func skyTemperatureCorrection(k []float64, ambient float64) float64 {
+	correction := k[0]/100*(ambient-k[1]/10) + k[2]/100*math.Pow(math.Exp(k[3]/1000*ambient), k[4]/100)
+
+	offset := k[1]/10 - ambient
+	sign := -1.0
+	if offset < 0 {
+		sign = 1.0
+	}
+	if math.Abs(offset) < 1 {
+		if k[5] != 0 {
+			correction += math.Copysign(1, k[5]) * sign * math.Abs(offset)
+		}
+	} else {
+		correction += k[5] / 10 * sign * (math.Log10(math.Abs(offset)) + k[6]/100)
+	}
+	return correction
+}
+
+func cloudCoverPercent(sky, ambient float64) float64 {
+	c := config.CloudCover
+	corrected := sky - skyTemperatureCorrection(c.Coefficients, ambient)
+	cover := (corrected - *c.ClearTemperature) / (*c.OvercastTemperature - *c.ClearTemperature) * 100
+	return math.Round(math.Max(0, math.Min(100, cover))*10) / 10
+}
+
+// deriveCloudCover estimates cloud cover once both temperatures are known,
+// dating it by the older of the two
+func deriveCloudCover(data *WeatherData) {
+	info, ok := derivedSensorInfo(data, "cloudCover")
+	if !ok {
+		return
+	}
+	data.CloudCover = cloudCoverPercent(data.SkyTemperature, data.AmbientTemperature)
+	data.Sensors["cloudCover"] = info
+}
+
+// timeRanges collects repeated -night flags of the form FROM/TO
+type timeRanges [][2]string
+
+func (t *timeRanges) String() string {
+	return fmt.Sprint(*t)
+}
+
+func (t *timeRanges) Set(value string) error {
+	from, to, ok := strings.Cut(value, "/")
+	if !ok {
+		return fmt.Errorf("expected FROM/TO, got %q", value)
+	}
+	*t = append(*t, [2]string{from, to})
+	return nil
+}
+
+// runCalibrateCloudsCommand fits K1 and K3 to logged clear nights, so that
+// the corrected sky temperature is flat across their ambient temperatures,
+// and prints the resulting CloudCover config
+func runCalibrateCloudsCommand(args []string) error {
+	flags := flag.NewFlagSet("calibrate-clouds", flag.ContinueOnError)
+	var nights timeRanges
+	flags.Var(&nights, "night", "Clear period as FROM/TO (RFC3339, Unix seconds or a duration ago); repeatable")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(nights) == 0 {
+		return fmt.Errorf("at least one -night is required")
+	}
+	if config.HistoryDir == "" {
+		return fmt.Errorf("HistoryDir is not specified in the config file")
+	}
+
+	store := &historyStore{dir: config.HistoryDir}
+	now := time.Now()
+	var ambient, sky []float64
+	for _, night := range nights {
+		from, err := parseHistoryTime(night[0], now)
+		if err != nil {
+			return err
+		}
+		to, err := parseHistoryTime(night[1], now)
+		if err != nil {
+			return err
+		}
+		samples, err := store.query(from, to)
+		if err != nil {
+			return err
+		}
+		for _, sample := range samples {
+			_, okSky := sample.Sensors["skyTemperature"]
+			_, okAmbient := sample.Sensors["ambientTemperature"]
+			if okSky && okAmbient {
+				ambient = append(ambient, sample.AmbientTemperature)
+				sky = append(sky, sample.SkyTemperature)
+			}
+		}
+	}
+	if len(sky) < 10 {
+		return fmt.Errorf("only %d samples with sky and ambient temperatures in the given nights", len(sky))
+	}
+
+	k := append([]float64(nil), config.CloudCover.Coefficients...)
+	exponential := func(t float64) float64 {
+		return math.Pow(math.Exp(k[3]/1000*t), k[4]/100)
+	}
+
+	// sky = intercept + K1/100 * ambient + K3/100 * exponential(ambient).
+	// The exponential term is only fitted when the nights span enough ambient
+	// temperatures to separate it from the linear one; otherwise K3 is kept.
+	minAmbient, maxAmbient := ambient[0], ambient[0]
+	for _, t := range ambient {
+		minAmbient, maxAmbient = math.Min(minAmbient, t), math.Max(maxAmbient, t)
+	}
+	rows := make([][]float64, len(sky))
+	y := make([]float64, len(sky))
+	fitExponential := maxAmbient-minAmbient >= minExponentialFitRange
+	for i, t := range ambient {
+		if fitExponential {
+			rows[i], y[i] = []float64{1, t, exponential(t)}, sky[i]
+		} else {
+			rows[i], y[i] = []float64{1, t}, sky[i]-k[2]/100*exponential(t)
+		}
+	}
+	fit, ok := leastSquares(rows, y)
+	if !ok {
+		return fmt.Errorf("clear night samples do not span a range of ambient temperatures")
+	}
+	k[0] = fit[1] * 100
+	if fitExponential {
+		k[2] = fit[2] * 100
+	}
+
+	// Clear samples should fall below the clear limit
+	var sum, sumSquares float64
+	for i := range sky {
+		corrected := sky[i] - skyTemperatureCorrection(k, ambient[i])
+		sum += corrected
+		sumSquares += corrected * corrected
+	}
+	n := float64(len(sky))
+	mean := sum / n
+	stddev := math.Sqrt(math.Max(0, sumSquares/n-mean*mean))
+	clear := math.Round((mean+2*stddev)*10) / 10
+
+	for i := range k {
+		k[i] = math.Round(k[i]*100) / 100
+	}
+	fmt.Fprintf(os.Stderr, "Fitted %d clear samples: corrected sky temperature %.1f ± %.1f °C\n", len(sky), mean, stddev)
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string]interface{}{"cloudCover": CloudCoverConfig{
+		Coefficients:        k,
+		ClearTemperature:    &clear,
+		OvercastTemperature: config.CloudCover.OvercastTemperature,
+	}})
+}
+
+// leastSquares solves the normal equations for the coefficients best fitting
+// y to the rows, reporting false when they are singular
+func leastSquares(rows [][]float64, y []float64) ([]float64, bool) {
+	n := len(rows[0])
+	a := make([][]float64, n)
+	for i := range a {
+		a[i] = make([]float64, n+1)
+	}
+	for r, row := range rows {
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				a[i][j] += row[i] * row[j]
+			}
+			a[i][n] += row[i] * y[r]
+		}
+	}
+
+	// Gaussian elimination with partial pivoting
+	for col := 0; col < n; col++ {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-9*float64(len(rows)) {
+			return nil, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for r := 0; r < n; r++ {
+			if r == col {
+				continue
+			}
+			factor := a[r][col] / a[col][col]
+			for c := col; c <= n; c++ {
+				a[r][c] -= factor * a[col][c]
+			}
+		}
+	}
+
+	solution := make([]float64, n)
+	for i := range solution {
+		solution[i] = a[i][n] / a[i][i]
+	}
+	return solution, true
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSkyTemperatureCorrection(t *testing.T) {
+	tests := []struct {
+		name    string
+		k       []float64
+		ambient float64
+		want    float64
+	}{
+		{"defaults at 0°C", defaultCloudCoefficients, 0, 0.04},
+		{"defaults at 20°C", defaultCloudCoefficients, 20, 6.6 + 0.04*math.Exp(2)},
+		{"defaults at -10°C", defaultCloudCoefficients, -10, -3.3 + 0.04*math.Exp(-1)},
+		{"K6 logarithmic term", []float64{33, 0, 4, 100, 100, 20, 0}, 10, 3.3 + 0.04*math.E + 2},
+		{"K6 within a degree of K2", []float64{33, 0, 4, 100, 100, 20, 0}, 0.5, 0.165 + 0.04*math.Exp(0.05) + 0.5},
+		{"K6 below K2", []float64{33, 0, 4, 100, 100, 20, 0}, -10, -3.3 + 0.04*math.Exp(-1) - 2},
+	}
+	for _, tt := range tests {
+		if got := skyTemperatureCorrection(tt.k, tt.ambient); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: correction = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCloudCoverPercent(t *testing.T) {
+	config = Config{}
+	if err := validateCloudCoverConfig(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		sky, ambient float64
+		want         float64
+	}{
+		{-30, 0, 0},
+		{-15 + 0.04, 0, 0},
+		{-7.5 + 0.04, 0, 50},
+		{0.04, 0, 100},
+		{10, 0, 100},
+	}
+	for _, tt := range tests {
+		if got := cloudCoverPercent(tt.sky, tt.ambient); got != tt.want {
+			t.Errorf("cloudCoverPercent(%v, %v) = %v, want %v", tt.sky, tt.ambient, got, tt.want)
+		}
+	}
+}
+
+func TestValidateCloudCoverConfig(t *testing.T) {
+	clear, overcast := -5.0, -10.0
+	tests := []struct {
+		name string
+		c    CloudCoverConfig
+	}{
+		{"too few coefficients", CloudCoverConfig{Coefficients: []float64{33, 0, 4}}},
+		{"overcast below clear", CloudCoverConfig{ClearTemperature: &clear, OvercastTemperature: &overcast}},
+	}
+	for _, tt := range tests {
+		config = Config{CloudCover: tt.c}
+		if err := validateCloudCoverConfig(); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestDeriveCloudCover(t *testing.T) {
+	config = Config{}
+	validateCloudCoverConfig()
+	older := time.Date(2024, 6, 21, 22, 0, 0, 0, time.UTC)
+
+	data := WeatherData{SkyTemperature: -7.46, AmbientTemperature: 0, Sensors: map[string]SensorInfo{
+		"skyTemperature":     {Source: "a", Updated: older.Add(time.Minute)},
+		"ambientTemperature": {Source: "b", Updated: older},
+	}}
+	deriveCloudCover(&data)
+	if data.CloudCover != 50 || data.Sensors["cloudCover"] != (SensorInfo{Source: "derived", Updated: older}) {
+		t.Errorf("cloud cover %v %+v, want 50 dated by the older input", data.CloudCover, data.Sensors["cloudCover"])
+	}
+
+	data = WeatherData{SkyTemperature: -20, Sensors: map[string]SensorInfo{"skyTemperature": {Updated: older}}}
+	deriveCloudCover(&data)
+	if _, ok := data.Sensors["cloudCover"]; ok {
+		t.Error("cloud cover derived without an ambient temperature")
+	}
+}
+
+func TestLeastSquares(t *testing.T) {
+	// y = 2 + 0.5x + 3e^(x/10), exactly
+	var rows [][]float64
+	var y []float64
+	for x := -10.0; x <= 20; x += 5 {
+		rows = append(rows, []float64{1, x, math.Exp(x / 10)})
+		y = append(y, 2+0.5*x+3*math.Exp(x/10))
+	}
+	fit, ok := leastSquares(rows, y)
+	if !ok {
+		t.Fatal("fit reported singular")
+	}
+	for i, want := range []float64{2, 0.5, 3} {
+		if math.Abs(fit[i]-want) > 1e-6 {
+			t.Errorf("coefficient %d = %v, want %v", i, fit[i], want)
+		}
+	}
+
+	// Noisy line: the fit minimises the squared residuals
+	fit, ok = leastSquares([][]float64{{1, 0}, {1, 1}, {1, 2}}, []float64{1, 2, 4})
+	if !ok || math.Abs(fit[0]-5.0/6) > 1e-9 || math.Abs(fit[1]-1.5) > 1e-9 {
+		t.Errorf("line fit = %v, %v; want 5/6 + 1.5x", fit, ok)
+	}
+
+	// A single ambient temperature cannot separate the intercept from the slope
+	if _, ok := leastSquares([][]float64{{1, 5}, {1, 5}, {1, 5}}, []float64{1, 2, 3}); ok {
+		t.Error("expected a singular fit for a constant column")
+	}
+}
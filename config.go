@@ -15,6 +15,59 @@ type Config struct {
 	WebServerPort   int    `json:"webServerPort"`
 	DiscoveryPort   int    `json:"discoveryPort"`
 	Timezone        string `json:"timezone"`
+
+	// Sensor fusion: several sources merged into one device
+	Sources       []SourceConfig      `json:"sources"`
+	FieldPriority map[string][]string `json:"fieldPriority"`
+	SourceMaxAge  string              `json:"sourceMaxAge"`
+
+	// How much history to keep in memory
+	HistoryDuration string `json:"historyDuration"`
+
+	// On-disk history; disabled when HistoryDir is empty. A zero
+	// HistoryRetention keeps history forever and a zero CompactAfter never
+	// compacts it.
+	HistoryDir       string `json:"historyDir"`
+	HistoryRetention string `json:"historyRetention"`
+	CompactAfter     string `json:"compactAfter"`
+	CompactStep      string `json:"compactStep"`
+
+	// Rules for the safety verdict
+	Safety SafetyConfig `json:"safety"`
+
+	// Time-series database push exporters
+	Exporters []ExporterConfig `json:"exporters"`
+
+	// MQTT publishing with Home Assistant discovery
+	MQTT MQTTConfig `json:"mqtt"`
+
+	// Boltwood II one-line data file output
+	BoltwoodFile BoltwoodFileConfig `json:"boltwoodFile"`
+
+	// Condition change notifications
+	Notifiers []NotifierConfig `json:"notifiers"`
+
+	// Roll-off roof exposed as an Alpaca Dome
+	Dome DomeConfig `json:"dome"`
+
+	// Weather flags and outputs exposed as an Alpaca Switch
+	Switch SwitchConfig `json:"switch"`
+
+	// Cloud cover estimate from the sky temperature
+	CloudCover CloudCoverConfig `json:"cloudCover"`
+
+	// Largest difference (°C) between the reported and calculated dew points
+	// before dewPointMismatch is set
+	DewPointTolerance float64 `json:"dewPointTolerance"`
+
+	// Observatory location for the sun and moon ephemeris
+	Site SiteConfig `json:"site"`
+
+	// Outlier filters for noisy numeric fields, keyed by field
+	Filters map[string]FilterConfig `json:"filters"`
+
+	// Period over which the condition trends are fitted (default 30m)
+	TrendWindow string `json:"trendWindow"`
 }
 
 var config Config
@@ -43,15 +96,13 @@ func loadConfig() error {
 		return err
 	}
 
-	log.Printf("Configuration loaded successfully: %+v", config)
+	// Log the configuration without exporter credentials
+	redacted, _ := json.Marshal(redactedConfig())
+	log.Printf("Configuration loaded successfully: %s", redacted)
 	return nil
 }
 
 func validateConfig() error {
-	if config.BoltwoodSource == "" {
-		return fmt.Errorf("BoltwoodSource is not specified in the config file")
-	}
-
 	if config.WebServerPort == 0 {
 		return fmt.Errorf("WebServerPort is not specified in the config file")
 	}
@@ -63,6 +114,86 @@ func validateConfig() error {
 	}
 	config.PollingInterval = duration.String()
 
+	// Validate the weather sources, defaulting to BoltwoodSource
+	if err := validateSources(); err != nil {
+		return err
+	}
+
+	if err := validateFilters(); err != nil {
+		return err
+	}
+
+	// Validate the site before the safety rules using it
+	if err := validateSiteConfig(); err != nil {
+		return err
+	}
+
+	// Validate the safety rules
+	if err := validateSafetyConfig(); err != nil {
+		return err
+	}
+	if err := validateTrendConfig(); err != nil {
+		return err
+	}
+
+	// Parse the history duration, defaulting to one day
+	if config.HistoryDuration == "" {
+		config.HistoryDuration = "24h"
+	}
+	historyDuration, err := time.ParseDuration(config.HistoryDuration)
+	if err != nil || historyDuration <= 0 {
+		return fmt.Errorf("invalid HistoryDuration in config file: %q", config.HistoryDuration)
+	}
+	config.HistoryDuration = historyDuration.String()
+
+	// Parse the on-disk history settings
+	for _, setting := range []struct {
+		name     string
+		value    *string
+		def      string
+		zeroOkay bool
+	}{
+		{"HistoryRetention", &config.HistoryRetention, "720h", true},
+		{"CompactAfter", &config.CompactAfter, "168h", true},
+		{"CompactStep", &config.CompactStep, "5m", false},
+	} {
+		if *setting.value == "" {
+			*setting.value = setting.def
+		}
+		duration, err := time.ParseDuration(*setting.value)
+		if err != nil || duration < 0 || (duration == 0 && !setting.zeroOkay) {
+			return fmt.Errorf("invalid %s in config file: %q", setting.name, *setting.value)
+		}
+		*setting.value = duration.String()
+	}
+
+	// Validate the push exporters
+	if err := validateExporters(); err != nil {
+		return err
+	}
+	if err := validateMQTTConfig(); err != nil {
+		return err
+	}
+	if err := validateNotifiers(); err != nil {
+		return err
+	}
+	if err := validateDomeConfig(); err != nil {
+		return err
+	}
+	if err := validateSwitchConfig(); err != nil {
+		return err
+	}
+	if err := validateCloudCoverConfig(); err != nil {
+		return err
+	}
+
+	// Reported dew points further than this from the calculated one are flagged
+	if config.DewPointTolerance == 0 {
+		config.DewPointTolerance = 2
+	} else if config.DewPointTolerance < 0 {
+		return fmt.Errorf("invalid DewPointTolerance in config file: %v", config.DewPointTolerance)
+	}
+
 	// Validate the timezone
 	if config.Timezone == "" {
 		config.Timezone = "UTC" // Default to UTC if not specified
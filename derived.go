@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+)
+
+// Magnus formula coefficients over water (Sonntag 1990)
+const (
+	magnusA = 17.62
+	magnusB = 243.12 // °C
+)
+
+// Dew point depressions (°C) at or below which dew is a moderate or high risk
+const (
+	dewRiskModerate = 4.0
+	dewRiskHigh     = 2.0
+)
+
+// magnusDewPoint is the dew point for an ambient temperature (°C) and
+// relative humidity (%)
+func magnusDewPoint(temperature, humidity float64) float64 {
+	gamma := math.Log(humidity/100) + magnusA*temperature/(magnusB+temperature)
+	return magnusB * gamma / (magnusA - gamma)
+}
+
+// absoluteHumidity is the water vapour density in g/m³
+func absoluteHumidity(temperature, humidity float64) float64 {
+	saturation := 6.112 * math.Exp(magnusA*temperature/(magnusB+temperature)) // hPa
+	return saturation * humidity * 2.1674 / (273.15 + temperature)
+}
+
+// windChill is the Environment Canada wind chill index for a temperature (°C)
+// and wind speed (m/s). Outside the index's range of 10 °C and below with
+// wind above 4.8 km/h, it is the air temperature.
+func windChill(temperature, windSpeed float64) float64 {
+	speed := windSpeed * 3.6
+	if temperature > 10 || speed <= 4.8 {
+		return temperature
+	}
+	v := math.Pow(speed, 0.16)
+	return 13.12 + 0.6215*temperature - 11.37*v + 0.3965*temperature*v
+}
+
+func parseDewRisk(val int) string {
+	switch val {
+	case 1:
+		return "Low"
+	case 2:
+		return "Moderate"
+	case 3:
+		return "High"
+	default:
+		return "Unknown"
+	}
+}
+
+func dewRisk(depression float64) string {
+	switch {
+	case depression <= dewRiskHigh:
+		return parseDewRisk(3)
+	case depression <= dewRiskModerate:
+		return parseDewRisk(2)
+	default:
+		return parseDewRisk(1)
+	}
+}
+
+// derivedSensorInfo dates a derived field by the oldest of its inputs,
+// reporting false while any input is missing
+func derivedSensorInfo(data *WeatherData, key string) (SensorInfo, bool) {
+	info := SensorInfo{Source: "derived"}
+	for _, input := range derivedWeatherFields[key] {
+		sensor, ok := data.Sensors[input]
+		if !ok {
+			return SensorInfo{}, false
+		}
+		if info.Updated.IsZero() || sensor.Updated.Before(info.Updated) {
+			info.Updated = sensor.Updated
+		}
+	}
+	return info, true
+}
+
+// deriveHumidityMetrics calculates the dew point and the quantities that
+// follow from it. A source without a dew point gets the calculated one, and
+// a reported dew point too far from it is flagged.
+func deriveHumidityMetrics(data *WeatherData) {
+	if info, ok := derivedSensorInfo(data, "calculatedDewPoint"); ok && data.Humidity > 0 {
+		data.CalculatedDewPoint = math.Round(magnusDewPoint(data.AmbientTemperature, data.Humidity)*10) / 10
+		data.AbsoluteHumidity = math.Round(absoluteHumidity(data.AmbientTemperature, data.Humidity)*100) / 100
+		data.Sensors["calculatedDewPoint"] = info
+		data.Sensors["absoluteHumidity"] = info
+
+		if _, reported := data.Sensors["dewPoint"]; !reported {
+			data.DewPoint = data.CalculatedDewPoint
+			data.Sensors["dewPoint"] = info
+		} else if math.Abs(data.DewPoint-data.CalculatedDewPoint) > config.DewPointTolerance {
+			data.DewPointMismatch = 1
+		}
+		if info, ok := derivedSensorInfo(data, "dewPointMismatch"); ok {
+			data.Sensors["dewPointMismatch"] = info
+		}
+	}
+
+	if info, ok := derivedSensorInfo(data, "dewPointDepression"); ok {
+		data.DewPointDepression = math.Round((data.AmbientTemperature-data.DewPoint)*10) / 10
+		data.DewRisk = dewRisk(data.DewPointDepression)
+		data.Sensors["dewPointDepression"] = info
+		data.Sensors["dewRisk"] = info
+	}
+
+	if info, ok := derivedSensorInfo(data, "windChill"); ok {
+		data.WindChill = math.Round(windChill(data.AmbientTemperature, data.WindSpeed)*10) / 10
+		data.Sensors["windChill"] = info
+	}
+}
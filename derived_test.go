@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMagnusDewPoint(t *testing.T) {
+	tests := []struct {
+		temperature, humidity float64
+		want                  float64
+	}{
+		{20, 50, 9.26},
+		{-5, 80, -7.92},
+		{10, 100, 10},
+		{25, 100, 25},
+	}
+	for _, tt := range tests {
+		if got := magnusDewPoint(tt.temperature, tt.humidity); math.Abs(got-tt.want) > 0.01 {
+			t.Errorf("magnusDewPoint(%v, %v) = %v, want %v", tt.temperature, tt.humidity, got, tt.want)
+		}
+	}
+}
+
+func TestAbsoluteHumidity(t *testing.T) {
+	tests := []struct {
+		temperature, humidity float64
+		want                  float64
+	}{
+		{20, 50, 8.62},
+		{0, 100, 4.85},
+		{30, 80, 24.22},
+		{15, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := absoluteHumidity(tt.temperature, tt.humidity); math.Abs(got-tt.want) > 0.01 {
+			t.Errorf("absoluteHumidity(%v, %v) = %v, want %v", tt.temperature, tt.humidity, got, tt.want)
+		}
+	}
+}
+
+func TestWindChill(t *testing.T) {
+	kmh := func(speed float64) float64 { return speed / 3.6 }
+	tests := []struct {
+		name                   string
+		temperature, windSpeed float64
+		want                   float64
+	}{
+		// Environment Canada's published table
+		{"-10°C at 20 km/h", -10, kmh(20), -17.9},
+		{"0°C at 30 km/h", 0, kmh(30), -6.5},
+		{"-20°C at 36 km/h", -20, 10, -33.6},
+		{"above 10°C", 10.5, 10, 10.5},
+		{"calm", -10, kmh(4.8), -10},
+	}
+	for _, tt := range tests {
+		if got := math.Round(windChill(tt.temperature, tt.windSpeed)*10) / 10; got != tt.want {
+			t.Errorf("%s: wind chill = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDewRisk(t *testing.T) {
+	tests := []struct {
+		depression float64
+		want       string
+	}{
+		{0, "High"},
+		{2, "High"},
+		{2.1, "Moderate"},
+		{4, "Moderate"},
+		{4.1, "Low"},
+		{15, "Low"},
+	}
+	for _, tt := range tests {
+		if got := dewRisk(tt.depression); got != tt.want {
+			t.Errorf("dewRisk(%v) = %q, want %q", tt.depression, got, tt.want)
+		}
+	}
+}
+
+func TestDeriveHumidityMetrics(t *testing.T) {
+	config = Config{DewPointTolerance: 1}
+	updated := time.Date(2024, 6, 21, 22, 0, 0, 0, time.UTC)
+	sample := func(dewPoint *float64) WeatherData {
+		data := WeatherData{AmbientTemperature: 20, Humidity: 50, WindSpeed: 2, Sensors: map[string]SensorInfo{
+			"ambientTemperature": {Source: "a", Updated: updated},
+			"humidity":           {Source: "a", Updated: updated.Add(-time.Minute)},
+			"windSpeed":          {Source: "a", Updated: updated},
+		}}
+		if dewPoint != nil {
+			data.DewPoint = *dewPoint
+			data.Sensors["dewPoint"] = SensorInfo{Source: "b", Updated: updated}
+		}
+		return data
+	}
+
+	tests := []struct {
+		name         string
+		dewPoint     *float64
+		wantDewPoint float64
+		wantMismatch int
+	}{
+		{"calculated when not reported", nil, 9.3, 0},
+		{"reported within tolerance", func() *float64 { v := 9.0; return &v }(), 9.0, 0},
+		{"reported too far off", func() *float64 { v := 12.0; return &v }(), 12.0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := sample(tt.dewPoint)
+			deriveHumidityMetrics(&data)
+			if data.CalculatedDewPoint != 9.3 || data.AbsoluteHumidity != 8.62 {
+				t.Errorf("calculated dew point %v, absolute humidity %v", data.CalculatedDewPoint, data.AbsoluteHumidity)
+			}
+			if data.DewPoint != tt.wantDewPoint || data.DewPointMismatch != tt.wantMismatch {
+				t.Errorf("dew point %v mismatch %d, want %v and %d", data.DewPoint, data.DewPointMismatch, tt.wantDewPoint, tt.wantMismatch)
+			}
+			if data.WindChill != 20 {
+				t.Errorf("wind chill %v above 10°C, want the air temperature", data.WindChill)
+			}
+			if info := data.Sensors["calculatedDewPoint"]; info.Source != "derived" || !info.Updated.Equal(updated.Add(-time.Minute)) {
+				t.Errorf("calculatedDewPoint dated %+v, want the older input", info)
+			}
+			if _, ok := data.Sensors["dewRisk"]; !ok || data.DewRisk == "" {
+				t.Error("dew risk not derived")
+			}
+		})
+	}
+}
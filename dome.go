@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DomeConfig enables the Alpaca dome/0 device for a roll-off roof. The device
+// is disabled when no actuator type is set.
+type DomeConfig struct {
+	Name     string         `json:"name"`
+	Actuator ActuatorConfig `json:"actuator"`
+
+	// How long a movement may take before the shutter is reported in error
+	MoveTimeout string `json:"moveTimeout"`
+
+	// Close automatically when conditions turn unsafe, and refuse to open
+	// while they are
+	AutoClose     bool                `json:"autoClose"`
+	ParkInterlock ParkInterlockConfig `json:"parkInterlock"`
+}
+
+// ASCOM ShutterState values
+const (
+	shutterOpen = iota
+	shutterClosed
+	shutterOpening
+	shutterClosing
+	shutterError
+)
+
+var shutterStates = map[string]int{
+	"open":    shutterOpen,
+	"closed":  shutterClosed,
+	"opening": shutterOpening,
+	"closing": shutterClosing,
+	"error":   shutterError,
+}
+
+var shutterStateNames = []string{"open", "closed", "opening", "closing", "error"}
+
+// rollOffRoof tracks the shutter of a roll-off roof. The actuator's feedback
+// is used when it has any, otherwise movements complete after the travel time.
+type rollOffRoof struct {
+	mu          sync.Mutex
+	actuator    actuator
+	travelTime  time.Duration
+	moveTimeout time.Duration
+	state       int
+	target      int
+	moveStarted time.Time
+	connected   bool
+}
+
+var dome *rollOffRoof
+
+func validateDomeConfig() error {
+	d := &config.Dome
+	if d.Actuator.Type == "" {
+		return nil
+	}
+	if d.Name == "" {
+		d.Name = "Roll-off Roof"
+	}
+	if err := validateActuatorConfig("dome", &d.Actuator, []string{"open", "close"}); err != nil {
+		return err
+	}
+	if d.MoveTimeout == "" {
+		d.MoveTimeout = "2m"
+	}
+	timeout, err := time.ParseDuration(d.MoveTimeout)
+	if err != nil || timeout <= 0 {
+		return fmt.Errorf("invalid Dome.MoveTimeout in config file: %q", d.MoveTimeout)
+	}
+	return validateParkInterlock()
+}
+
+func initDome() {
+	if config.Dome.Actuator.Type == "" {
+		return
+	}
+	travelTime, _ := time.ParseDuration(config.Dome.Actuator.TravelTime)
+	moveTimeout, _ := time.ParseDuration(config.Dome.MoveTimeout)
+	dome = &rollOffRoof{
+		actuator:    newActuator(config.Dome.Actuator),
+		travelTime:  travelTime,
+		moveTimeout: moveTimeout,
+		state:       shutterClosed,
+	}
+
+	if reported, err := dome.actuator.status(); err != nil || reported == "" {
+		log.Printf("Roof state unknown at startup, assuming closed")
+	} else {
+		dome.adopt(reported)
+		log.Printf("Roof is %s", reported)
+	}
+}
+
+func (d *rollOffRoof) moving() bool {
+	return d.state == shutterOpening || d.state == shutterClosing
+}
+
+// adopt takes the state reported by the actuator. A movement the roof's own
+// controller started is timed from when it is first seen.
+func (d *rollOffRoof) adopt(reported string) {
+	d.state = shutterStates[reported]
+	switch d.state {
+	case shutterOpening:
+		d.target, d.moveStarted = shutterOpen, time.Now()
+	case shutterClosing:
+		d.target, d.moveStarted = shutterClosed, time.Now()
+	}
+}
+
+// update refreshes the shutter state from the actuator. Must be called with
+// d.mu held.
+func (d *rollOffRoof) update() error {
+	reported, err := d.actuator.status()
+	if err != nil {
+		return err
+	}
+
+	if !d.moving() {
+		// The roof may also be moved by its own controller
+		if reported != "" {
+			d.adopt(reported)
+		}
+		return nil
+	}
+
+	elapsed := time.Since(d.moveStarted)
+	switch {
+	case reported != "" && shutterStates[reported] == d.target:
+		d.state = d.target
+	case reported == "error":
+		d.state = shutterError
+	case reported == "" && elapsed >= d.travelTime:
+		d.state = d.target
+	case elapsed >= d.moveTimeout:
+		log.Printf("Roof did not finish moving within %s", d.moveTimeout)
+		d.state = shutterError
+	}
+	if !d.moving() {
+		log.Printf("Roof is %s", shutterStateNames[d.state])
+	}
+	return nil
+}
+
+func (d *rollOffRoof) shutterStatus() (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.update(); err != nil {
+		return shutterError, err
+	}
+	return d.state, nil
+}
+
+func (d *rollOffRoof) slewing() (bool, error) {
+	state, err := d.shutterStatus()
+	return state == shutterOpening || state == shutterClosing, err
+}
+
+// move commands the roof towards shutterOpen or shutterClosed
+func (d *rollOffRoof) move(target int, reason string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.update(); err != nil {
+		return err
+	}
+	if d.state == target || (d.moving() && d.target == target) {
+		return nil
+	}
+
+	command, moving := "open", shutterOpening
+	if target == shutterClosed {
+		command, moving = "close", shutterClosing
+	}
+	if err := d.actuator.command(command); err != nil {
+		return err
+	}
+	log.Printf("Roof %s: %s", shutterStateNames[moving], reason)
+	d.state, d.target, d.moveStarted = moving, target, time.Now()
+	return nil
+}
+
+func (d *rollOffRoof) abort() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.moving() {
+		return nil
+	}
+	if err := d.actuator.command("stop"); err != nil {
+		return err
+	}
+	log.Printf("Roof movement aborted")
+	d.state = shutterError
+	return nil
+}
+
+// domeNotImplemented answers properties and methods a roll-off roof lacks
+func domeNotImplemented(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handleAlpacaRequest(w, r, r.Method, func() (interface{}, error) {
+			return nil, newAlpacaError(errNotImplemented, "%s is not implemented by a roll-off roof", name)
+		})
+	}
+}
+
+func handleShutterStatus(w http.ResponseWriter, r *http.Request) {
+	handleAlpacaResponse(w, r, func() (interface{}, error) {
+		return dome.shutterStatus()
+	})
+}
+
+func handleDomeSlewing(w http.ResponseWriter, r *http.Request) {
+	handleAlpacaResponse(w, r, func() (interface{}, error) {
+		return dome.slewing()
+	})
+}
+
+// checkDomeConnected rejects shutter commands while the device is disconnected
+func checkDomeConnected() error {
+	dome.mu.Lock()
+	defer dome.mu.Unlock()
+	if !dome.connected {
+		return newAlpacaError(errNotConnected, "Dome is not connected")
+	}
+	return nil
+}
+
+func handleOpenShutter(w http.ResponseWriter, r *http.Request) {
+	handleAlpacaAction(w, r, func() error {
+		if err := checkDomeConnected(); err != nil {
+			return err
+		}
+		if config.Dome.AutoClose {
+			if verdict := currentSafety(); !verdict.Safe {
+				log.Printf("Refusing to open roof for %s: conditions unsafe (%s)", r.RemoteAddr, strings.Join(verdict.Reasons, "; "))
+				return newAlpacaError(errInvalidOperation, "Conditions are unsafe: %s", strings.Join(verdict.Reasons, "; "))
+			}
+		}
+		return dome.move(shutterOpen, "open requested by "+r.RemoteAddr)
+	})
+}
+
+func handleCloseShutter(w http.ResponseWriter, r *http.Request) {
+	handleAlpacaAction(w, r, func() error {
+		if err := checkDomeConnected(); err != nil {
+			return err
+		}
+		return dome.move(shutterClosed, "close requested by "+r.RemoteAddr)
+	})
+}
+
+func handleAbortSlew(w http.ResponseWriter, r *http.Request) {
+	handleAlpacaAction(w, r, func() error {
+		if err := checkDomeConnected(); err != nil {
+			return err
+		}
+		return dome.abort()
+	})
+}
+
+func handleDomeSlaved(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		handleAlpacaResponse(w, r, func() (interface{}, error) {
+			return false, nil
+		})
+		return
+	}
+	handleAlpacaAction(w, r, func() error {
+		slaved, err := strconv.ParseBool(getAlpacaParam(r, "Slaved"))
+		if err != nil {
+			return newAlpacaError(errInvalidValue, "Invalid Slaved value")
+		}
+		if slaved {
+			return newAlpacaError(errNotImplemented, "A roll-off roof cannot be slaved")
+		}
+		return nil
+	})
+}
+
+func setupDomeRoutes(router *mux.Router) {
+	const prefix = "/api/v1/dome/0/"
+
+	router.HandleFunc(prefix+"connected", alpacaConnected(&dome.connected, &dome.mu)).Methods("GET", "PUT")
+	router.HandleFunc(prefix+"description", alpacaProperty("Roll-off roof driven by "+config.Dome.Actuator.Type)).Methods("GET")
+	router.HandleFunc(prefix+"driverinfo", alpacaProperty("ASCOM Alpaca Boltwood II Roll-off Roof Driver v"+driverVersion)).Methods("GET")
+	router.HandleFunc(prefix+"driverversion", alpacaProperty("v"+driverVersion)).Methods("GET")
+	router.HandleFunc(prefix+"interfaceversion", alpacaProperty(2)).Methods("GET")
+	router.HandleFunc(prefix+"name", alpacaProperty(config.Dome.Name)).Methods("GET")
+	router.HandleFunc(prefix+"supportedactions", alpacaProperty([]string{})).Methods("GET")
+
+	// Shutter control
+	router.HandleFunc(prefix+"cansetshutter", alpacaProperty(true)).Methods("GET")
+	router.HandleFunc(prefix+"shutterstatus", handleShutterStatus).Methods("GET")
+	router.HandleFunc(prefix+"slewing", handleDomeSlewing).Methods("GET")
+	router.HandleFunc(prefix+"openshutter", handleOpenShutter).Methods("PUT")
+	router.HandleFunc(prefix+"closeshutter", handleCloseShutter).Methods("PUT")
+	router.HandleFunc(prefix+"abortslew", handleAbortSlew).Methods("PUT")
+
+	// A roll-off roof has no azimuth, altitude, home or park position
+	for _, capability := range []string{"canfindhome", "canpark", "cansetaltitude", "cansetazimuth",
+		"cansetpark", "canslave", "cansyncazimuth", "athome", "atpark"} {
+		router.HandleFunc(prefix+capability, alpacaProperty(false)).Methods("GET")
+	}
+	router.HandleFunc(prefix+"slaved", handleDomeSlaved).Methods("GET", "PUT")
+	for _, property := range []string{"altitude", "azimuth"} {
+		router.HandleFunc(prefix+property, domeNotImplemented(property)).Methods("GET")
+	}
+	for _, method := range []string{"findhome", "park", "setpark", "slewtoaltitude", "slewtoazimuth", "synctoazimuth"} {
+		router.HandleFunc(prefix+method, domeNotImplemented(method)).Methods("PUT")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRoof(travelTime, moveTimeout time.Duration) (*rollOffRoof, *simulatedActuator) {
+	actuator := &simulatedActuator{travelTime: travelTime, state: "closed", since: time.Now()}
+	return &rollOffRoof{
+		actuator:    actuator,
+		travelTime:  travelTime,
+		moveTimeout: moveTimeout,
+		state:       shutterClosed,
+		connected:   true,
+	}, actuator
+}
+
+func TestRollOffRoofMove(t *testing.T) {
+	const travel = 20 * time.Millisecond
+	tests := []struct {
+		name        string
+		start       string
+		target      int
+		moveTimeout time.Duration
+		travelTime  time.Duration
+		moving      int
+		want        int
+	}{
+		{"open", "closed", shutterOpen, time.Second, travel, shutterOpening, shutterOpen},
+		{"close", "open", shutterClosed, time.Second, travel, shutterClosing, shutterClosed},
+		{"timeout", "closed", shutterOpen, travel, time.Hour, shutterOpening, shutterError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, actuator := newTestRoof(tt.travelTime, tt.moveTimeout)
+			actuator.state, d.state = tt.start, shutterStates[tt.start]
+
+			if err := d.move(tt.target, "test"); err != nil {
+				t.Fatal(err)
+			}
+			if state, _ := d.shutterStatus(); state != tt.moving {
+				t.Fatalf("state while moving = %s, want %s", shutterStateNames[state], shutterStateNames[tt.moving])
+			}
+			time.Sleep(2 * travel)
+			if state, _ := d.shutterStatus(); state != tt.want {
+				t.Errorf("state = %s, want %s", shutterStateNames[state], shutterStateNames[tt.want])
+			}
+		})
+	}
+}
+
+func TestRollOffRoofAbort(t *testing.T) {
+	d, actuator := newTestRoof(time.Hour, time.Hour)
+	if err := d.move(shutterOpen, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.abort(); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := d.shutterStatus(); state != shutterError || actuator.state != "error" {
+		t.Errorf("after abort state = %s, actuator %q; want error", shutterStateNames[state], actuator.state)
+	}
+
+	// Aborting a roof at rest does nothing
+	d, actuator = newTestRoof(time.Hour, time.Hour)
+	if err := d.abort(); err != nil || d.state != shutterClosed || actuator.state != "closed" {
+		t.Errorf("abort at rest: state %s, actuator %q, %v", shutterStateNames[d.state], actuator.state, err)
+	}
+}
+
+func TestRollOffRoofAdoptsControllerMove(t *testing.T) {
+	tests := []struct {
+		name        string
+		travelTime  time.Duration
+		moveTimeout time.Duration
+		want        int
+	}{
+		{"completes", 20 * time.Millisecond, time.Second, shutterClosed},
+		{"times out", time.Hour, 20 * time.Millisecond, shutterError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, actuator := newTestRoof(tt.travelTime, tt.moveTimeout)
+			actuator.state, d.state = "open", shutterOpen
+
+			// The roof's own controller starts closing it
+			actuator.state, actuator.since = "closing", time.Now()
+			if state, _ := d.shutterStatus(); state != shutterClosing || d.target != shutterClosed {
+				t.Fatalf("state = %s, target %s; want closing to closed", shutterStateNames[state], shutterStateNames[d.target])
+			}
+			if time.Since(d.moveStarted) > time.Second {
+				t.Errorf("movement timed from %v", d.moveStarted)
+			}
+
+			time.Sleep(40 * time.Millisecond)
+			if state, _ := d.shutterStatus(); state != tt.want {
+				t.Errorf("state = %s, want %s", shutterStateNames[state], shutterStateNames[tt.want])
+			}
+		})
+	}
+}
+
+func TestShutterCommandsRequireConnection(t *testing.T) {
+	config = Config{}
+	for _, tt := range []struct {
+		path    string
+		handler http.HandlerFunc
+	}{
+		{"openshutter", handleOpenShutter},
+		{"closeshutter", handleCloseShutter},
+		{"abortslew", handleAbortSlew},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			var actuator *simulatedActuator
+			dome, actuator = newTestRoof(time.Hour, time.Hour)
+			if tt.path == "abortslew" {
+				dome.move(shutterOpen, "test")
+			}
+			dome.connected = false
+			before := actuator.state
+
+			form := url.Values{"ClientID": {"1"}, "ClientTransactionID": {"1"}}
+			r := httptest.NewRequest(http.MethodPut, "/api/v1/dome/0/"+tt.path, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+
+			var response struct{ ErrorNumber int }
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.ErrorNumber != errNotConnected {
+				t.Errorf("ErrorNumber = 0x%X, want 0x%X", response.ErrorNumber, errNotConnected)
+			}
+			if actuator.state != before {
+				t.Errorf("actuator changed from %q to %q while disconnected", before, actuator.state)
+			}
+		})
+	}
+	dome = nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// SiteConfig is the observatory location used for the sun and moon. The
+// ephemeris is disabled until both Latitude and Longitude are set.
+type SiteConfig struct {
+	Latitude  *float64 `json:"latitude"`  // degrees, north positive
+	Longitude *float64 `json:"longitude"` // degrees, east positive
+	Elevation float64  `json:"elevation"` // metres above sea level
+}
+
+// Ephemeris is the position of the sun and moon for the site at a time, with
+// the twilight times of the night containing it
+type Ephemeris struct {
+	Time              time.Time     `json:"time"`
+	Sun               SunEphemeris  `json:"sun"`
+	Moon              MoonEphemeris `json:"moon"`
+	DaylightCondition string        `json:"daylightCondition"`
+	Night             NightEvents   `json:"night"`
+}
+
+type SunEphemeris struct {
+	Altitude float64 `json:"altitude"`
+	Azimuth  float64 `json:"azimuth"`
+	Twilight string  `json:"twilight"`
+}
+
+type MoonEphemeris struct {
+	Altitude     float64 `json:"altitude"`
+	Azimuth      float64 `json:"azimuth"`
+	Illumination float64 `json:"illumination"` // percent
+	Phase        string  `json:"phase"`
+	Age          float64 `json:"age"` // days since new moon
+}
+
+// NightEvents are the sun's crossings between local noon and the next. An
+// event is omitted when the sun does not reach its altitude that night.
+type NightEvents struct {
+	Sunset           *time.Time `json:"sunset,omitempty"`
+	CivilDusk        *time.Time `json:"civilDusk,omitempty"`
+	NauticalDusk     *time.Time `json:"nauticalDusk,omitempty"`
+	AstronomicalDusk *time.Time `json:"astronomicalDusk,omitempty"`
+	AstronomicalDawn *time.Time `json:"astronomicalDawn,omitempty"`
+	NauticalDawn     *time.Time `json:"nauticalDawn,omitempty"`
+	CivilDawn        *time.Time `json:"civilDawn,omitempty"`
+	Sunrise          *time.Time `json:"sunrise,omitempty"`
+}
+
+// Sun altitudes (degrees) ending civil, nautical and astronomical twilight
+const (
+	civilTwilight        = -6.0
+	nauticalTwilight     = -12.0
+	astronomicalTwilight = -18.0
+)
+
+const synodicMonth = 29.530588 // days
+
+func validateSiteConfig() error {
+	site := config.Site
+	if (site.Latitude == nil) != (site.Longitude == nil) {
+		return fmt.Errorf("Site needs both latitude and longitude")
+	}
+	if site.Latitude != nil && (*site.Latitude < -90 || *site.Latitude > 90) {
+		return fmt.Errorf("invalid Site.Latitude in config file: %v", *site.Latitude)
+	}
+	if site.Longitude != nil && (*site.Longitude < -180 || *site.Longitude > 180) {
+		return fmt.Errorf("invalid Site.Longitude in config file: %v", *site.Longitude)
+	}
+	return nil
+}
+
+func siteConfigured() bool {
+	return config.Site.Latitude != nil && config.Site.Longitude != nil
+}
+
+// Trigonometry in degrees
+func sinDeg(deg float64) float64 { return math.Sin(deg * math.Pi / 180) }
+func cosDeg(deg float64) float64 { return math.Cos(deg * math.Pi / 180) }
+func tanDeg(deg float64) float64 { return math.Tan(deg * math.Pi / 180) }
+
+func toDegrees(rad float64) float64 { return rad * 180 / math.Pi }
+
+// daysSinceJ2000 is the number of days since 2000-01-01 12:00 TT, ignoring
+// the minute or so between TT and UTC
+func daysSinceJ2000(t time.Time) float64 {
+	return float64(t.UTC().UnixMilli())/86400000 - 10957.5
+}
+
+// sunEcliptic returns the sun's ecliptic longitude in degrees, using the
+// Astronomical Almanac's low precision formulae (about 0.01°)
+func sunEcliptic(n float64) float64 {
+	l := 280.460 + 0.9856474*n
+	g := 357.528 + 0.9856003*n
+	return math.Mod(l+1.915*sinDeg(g)+0.020*sinDeg(2*g), 360)
+}
+
+// moonEcliptic returns the moon's ecliptic longitude and latitude and its
+// horizontal parallax in degrees, using the Astronomical Almanac's low
+// precision formulae (about 0.3°)
+func moonEcliptic(n float64) (float64, float64, float64) {
+	t := n / 36525
+	lon := 218.32 + 481267.881*t +
+		6.29*sinDeg(135.0+477198.87*t) - 1.27*sinDeg(259.3-413335.36*t) +
+		0.66*sinDeg(235.7+890534.22*t) + 0.21*sinDeg(269.9+954397.74*t) -
+		0.19*sinDeg(357.5+35999.05*t) - 0.11*sinDeg(186.5+966404.03*t)
+	lat := 5.13*sinDeg(93.3+483202.02*t) + 0.28*sinDeg(228.2+960400.89*t) -
+		0.28*sinDeg(318.3+6003.15*t) - 0.17*sinDeg(217.6-407332.21*t)
+	parallax := 0.9508 + 0.0518*cosDeg(135.0+477198.87*t) + 0.0095*cosDeg(259.3-413335.36*t) +
+		0.0078*cosDeg(235.7+890534.22*t) + 0.0028*cosDeg(269.9+954397.74*t)
+	return math.Mod(lon, 360), lat, parallax
+}
+
+// horizontal converts ecliptic coordinates at n days since J2000 to the
+// altitude and azimuth (from north through east) seen from the site
+func horizontal(n, lon, lat float64) (float64, float64) {
+	obliquity := 23.439 - 0.0000004*n
+	ra := toDegrees(math.Atan2(sinDeg(lon)*cosDeg(obliquity)-tanDeg(lat)*sinDeg(obliquity), cosDeg(lon)))
+	dec := toDegrees(math.Asin(sinDeg(lat)*cosDeg(obliquity) + cosDeg(lat)*sinDeg(obliquity)*sinDeg(lon)))
+
+	siteLat, siteLon := *config.Site.Latitude, *config.Site.Longitude
+	sidereal := 280.46061837 + 360.98564736629*n + siteLon
+	hourAngle := sidereal - ra
+
+	altitude := toDegrees(math.Asin(sinDeg(siteLat)*sinDeg(dec) + cosDeg(siteLat)*cosDeg(dec)*cosDeg(hourAngle)))
+	azimuth := toDegrees(math.Atan2(sinDeg(hourAngle), cosDeg(hourAngle)*sinDeg(siteLat)-tanDeg(dec)*cosDeg(siteLat))) + 180
+	return altitude, math.Mod(azimuth, 360)
+}
+
+// sunAltitude is the geometric altitude of the sun's centre
+func sunAltitude(t time.Time) float64 {
+	n := daysSinceJ2000(t)
+	altitude, _ := horizontal(n, sunEcliptic(n), 0)
+	return altitude
+}
+
+// horizonAltitude is the sun's altitude at sunrise and sunset: refraction and
+// the sun's radius, plus the dip of the horizon seen from the site's elevation
+func horizonAltitude() float64 {
+	return -0.833 - 0.0347*math.Sqrt(math.Max(0, config.Site.Elevation))
+}
+
+func twilightState(altitude float64) string {
+	switch {
+	case altitude >= horizonAltitude():
+		return "Daylight"
+	case altitude >= civilTwilight:
+		return "Civil Twilight"
+	case altitude >= nauticalTwilight:
+		return "Nautical Twilight"
+	case altitude >= astronomicalTwilight:
+		return "Astronomical Twilight"
+	default:
+		return "Night"
+	}
+}
+
+// daylightCode maps the sun's altitude to the Boltwood daylight condition:
+// dark after civil twilight, light during it and very light with the sun up
+func daylightCode(altitude float64) int {
+	switch {
+	case altitude >= horizonAltitude():
+		return 3
+	case altitude >= civilTwilight:
+		return 2
+	default:
+		return 1
+	}
+}
+
+func moonPhase(elongation float64) string {
+	phases := []string{"New Moon", "Waxing Crescent", "First Quarter", "Waxing Gibbous",
+		"Full Moon", "Waning Gibbous", "Last Quarter", "Waning Crescent"}
+	return phases[int(math.Mod(elongation+22.5, 360)/45)]
+}
+
+func roundHundredths(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+func computeEphemeris(t time.Time) Ephemeris {
+	ephemeris := skyPositions(t)
+	ephemeris.Night = nightEvents(t)
+	return ephemeris
+}
+
+// skyPositions is the ephemeris without the night events, which take a
+// search over the whole day
+func skyPositions(t time.Time) Ephemeris {
+	n := daysSinceJ2000(t)
+	sunLon := sunEcliptic(n)
+	sunAlt, sunAz := horizontal(n, sunLon, 0)
+
+	moonLon, moonLat, parallax := moonEcliptic(n)
+	moonAlt, moonAz := horizontal(n, moonLon, moonLat)
+	moonAlt -= parallax * cosDeg(moonAlt) // topocentric
+	elongation := math.Mod(moonLon-sunLon+720, 360)
+
+	return Ephemeris{
+		Time: t.UTC(),
+		Sun: SunEphemeris{
+			Altitude: roundHundredths(sunAlt),
+			Azimuth:  roundHundredths(sunAz),
+			Twilight: twilightState(sunAlt),
+		},
+		Moon: MoonEphemeris{
+			Altitude:     roundHundredths(moonAlt),
+			Azimuth:      roundHundredths(moonAz),
+			Illumination: math.Round((1-cosDeg(moonLat)*cosDeg(elongation))/2*1000) / 10,
+			Phase:        moonPhase(elongation),
+			Age:          math.Round(elongation/360*synodicMonth*10) / 10,
+		},
+		DaylightCondition: parseDaylightCondition(daylightCode(sunAlt)),
+	}
+}
+
+// nightEvents finds the twilight times between the local noons around t
+func nightEvents(t time.Time) NightEvents {
+	loc, err := time.LoadLocation(config.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := t.In(loc)
+	start := time.Date(local.Year(), local.Month(), local.Day(), 12, 0, 0, 0, loc)
+	if local.Before(start) {
+		start = start.AddDate(0, 0, -1)
+	}
+	end := start.AddDate(0, 0, 1)
+
+	var night NightEvents
+	night.Sunset, night.Sunrise = sunCrossings(start, end, horizonAltitude())
+	night.CivilDusk, night.CivilDawn = sunCrossings(start, end, civilTwilight)
+	night.NauticalDusk, night.NauticalDawn = sunCrossings(start, end, nauticalTwilight)
+	night.AstronomicalDusk, night.AstronomicalDawn = sunCrossings(start, end, astronomicalTwilight)
+	return night
+}
+
+// sunCrossings returns the first times between start and end that the sun
+// sets below and rises above an altitude
+func sunCrossings(start, end time.Time, altitude float64) (setting, rising *time.Time) {
+	const step = 10 * time.Minute
+	above := sunAltitude(start) > altitude
+	for t := start; t.Before(end); t = t.Add(step) {
+		next := t.Add(step)
+		nextAbove := sunAltitude(next) > altitude
+		if nextAbove == above {
+			continue
+		}
+
+		// Bisect to the second
+		lo, hi := t, next
+		for hi.Sub(lo) > time.Second {
+			mid := lo.Add(hi.Sub(lo) / 2)
+			if (sunAltitude(mid) > altitude) == above {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		crossing := hi.Round(time.Second).UTC()
+		if above && setting == nil {
+			setting = &crossing
+		} else if !above && rising == nil {
+			rising = &crossing
+		}
+		above = nextAbove
+	}
+	return setting, rising
+}
+
+// deriveEphemeris records the sun and moon at the time of the sample
+func deriveEphemeris(data *WeatherData, now time.Time) {
+	if !siteConfigured() {
+		return
+	}
+	ephemeris := skyPositions(now)
+	data.SunAltitude = ephemeris.Sun.Altitude
+	data.MoonAltitude = ephemeris.Moon.Altitude
+	data.MoonIllumination = ephemeris.Moon.Illumination
+	data.DaylightCondition = ephemeris.DaylightCondition
+	for _, key := range []string{"sunAltitude", "moonAltitude", "moonIllumination", "daylightCondition"} {
+		data.Sensors[key] = SensorInfo{Source: "derived", Updated: now}
+	}
+}
+
+// handleEphemerisAPI serves the ephemeris for now or the time parameter
+func handleEphemerisAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !siteConfigured() {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "site latitude and longitude are not configured"})
+		return
+	}
+
+	t := time.Now()
+	if value := r.URL.Query().Get("time"); value != "" {
+		var err error
+		if t, err = parseHistoryTime(value, t); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(computeEphemeris(t))
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func setTestSite(latitude, longitude float64, timezone string) {
+	config = Config{Site: SiteConfig{Latitude: &latitude, Longitude: &longitude}, Timezone: timezone}
+}
+
+func mustParseTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+// angleDiff is the difference between two angles in degrees, -180 to 180
+func angleDiff(a, b float64) float64 {
+	return math.Mod(a-b+540, 360) - 180
+}
+
+func TestSunEclipticAtEquinoxesAndSolstices(t *testing.T) {
+	// 2024 equinoxes and solstices, from the US Naval Observatory
+	tests := []struct {
+		time string
+		want float64
+	}{
+		{"2024-03-20T03:06:00Z", 0},
+		{"2024-06-20T20:51:00Z", 90},
+		{"2024-09-22T12:44:00Z", 180},
+		{"2024-12-21T09:20:00Z", 270},
+	}
+	for _, tt := range tests {
+		got := sunEcliptic(daysSinceJ2000(mustParseTime(t, tt.time)))
+		if math.Abs(angleDiff(got, tt.want)) > 0.02 {
+			t.Errorf("sun longitude at %s = %.3f°, want %v°", tt.time, got, tt.want)
+		}
+	}
+}
+
+func TestHorizontalSunAtNoon(t *testing.T) {
+	tests := []struct {
+		name                string
+		latitude, longitude float64
+		time                string
+		altitude, azimuth   float64
+	}{
+		// Noon altitude is 90° less the latitude, plus the sun's declination
+		{"Greenwich at the equinox", 51.4779, 0, "2024-03-20T12:07:00Z", 38.67, 180},
+		{"equator at the equinox", 0, 0, "2024-03-20T12:07:00Z", 89.83, 28},
+		{"Sydney at the December solstice", -33.86, 151.21, "2024-12-21T01:53:00Z", 79.58, 0},
+	}
+	for _, tt := range tests {
+		setTestSite(tt.latitude, tt.longitude, "UTC")
+		n := daysSinceJ2000(mustParseTime(t, tt.time))
+		altitude, azimuth := horizontal(n, sunEcliptic(n), 0)
+		if math.Abs(altitude-tt.altitude) > 0.05 {
+			t.Errorf("%s: altitude %.2f°, want %.2f°", tt.name, altitude, tt.altitude)
+		}
+		// Near the zenith the azimuth swings quickly, so it is only checked roughly
+		if math.Abs(angleDiff(azimuth, tt.azimuth)) > 1 {
+			t.Errorf("%s: azimuth %.2f°, want %.2f°", tt.name, azimuth, tt.azimuth)
+		}
+	}
+}
+
+func TestMoonPhases(t *testing.T) {
+	setTestSite(51.4779, 0, "UTC")
+	// Lunar phases from the US Naval Observatory
+	tests := []struct {
+		time         string
+		phase        string
+		illumination float64
+		elongation   float64
+	}{
+		{"2024-01-11T11:57:00Z", "New Moon", 0, 0},
+		{"2024-01-18T03:53:00Z", "First Quarter", 50, 90},
+		{"2024-04-23T23:49:00Z", "Full Moon", 100, 180},
+		{"2024-10-24T08:03:00Z", "Last Quarter", 50, 270},
+	}
+	for _, tt := range tests {
+		at := mustParseTime(t, tt.time)
+		n := daysSinceJ2000(at)
+		moonLon, _, _ := moonEcliptic(n)
+		if elongation := angleDiff(moonLon, sunEcliptic(n)); math.Abs(angleDiff(elongation, tt.elongation)) > 1 {
+			t.Errorf("%s: moon %.2f° from the sun, want %v°", tt.time, elongation, tt.elongation)
+		}
+		moon := computeEphemeris(at).Moon
+		if moon.Phase != tt.phase || math.Abs(moon.Illumination-tt.illumination) > 1 {
+			t.Errorf("%s: %s %.1f%% lit, want %s %v%%", tt.time, moon.Phase, moon.Illumination, tt.phase, tt.illumination)
+		}
+	}
+
+	// At the total lunar eclipse of 2025-03-14 the moon is opposite the sun,
+	// on the ecliptic
+	n := daysSinceJ2000(mustParseTime(t, "2025-03-14T06:58:00Z"))
+	moonLon, moonLat, parallax := moonEcliptic(n)
+	if math.Abs(angleDiff(moonLon, sunEcliptic(n)+180)) > 0.5 || math.Abs(moonLat) > 0.5 {
+		t.Errorf("eclipsed moon at longitude %.2f°, latitude %.2f°", moonLon, moonLat)
+	}
+	if parallax < 0.9 || parallax > 1.01 {
+		t.Errorf("moon parallax %.3f°", parallax)
+	}
+}
+
+func TestNightEvents(t *testing.T) {
+	type event struct {
+		name string
+		got  *time.Time
+		want string
+	}
+	tests := []struct {
+		name                string
+		latitude, longitude float64
+		timezone            string
+		time                string
+		events              func(NightEvents) []event
+	}{
+		{
+			// Published times for Greenwich, to the minute
+			name: "Greenwich in December", latitude: 51.4779, longitude: 0, timezone: "Europe/London",
+			time: "2024-12-21T18:00:00Z",
+			events: func(night NightEvents) []event {
+				return []event{
+					{"sunset", night.Sunset, "2024-12-21T15:53:00Z"},
+					{"civil dusk", night.CivilDusk, "2024-12-21T16:34:00Z"},
+					{"nautical dusk", night.NauticalDusk, "2024-12-21T17:17:00Z"},
+					{"astronomical dusk", night.AstronomicalDusk, "2024-12-21T17:57:00Z"},
+					{"astronomical dawn", night.AstronomicalDawn, "2024-12-22T06:00:00Z"},
+					{"nautical dawn", night.NauticalDawn, "2024-12-22T06:41:00Z"},
+					{"civil dawn", night.CivilDawn, "2024-12-22T07:24:00Z"},
+					{"sunrise", night.Sunrise, "2024-12-22T08:04:00Z"},
+				}
+			},
+		},
+		{
+			name: "Greenwich at midsummer", latitude: 51.4779, longitude: 0, timezone: "Europe/London",
+			time: "2024-06-22T01:00:00Z",
+			events: func(night NightEvents) []event {
+				return []event{
+					{"sunset", night.Sunset, "2024-06-21T20:21:00Z"},
+					{"sunrise", night.Sunrise, "2024-06-22T03:43:00Z"},
+					// The sun stays above -18°
+					{"astronomical dusk", night.AstronomicalDusk, ""},
+					{"astronomical dawn", night.AstronomicalDawn, ""},
+				}
+			},
+		},
+		{
+			name: "New York in December", latitude: 40.7128, longitude: -74.006, timezone: "America/New_York",
+			time: "2024-12-21T20:00:00Z",
+			events: func(night NightEvents) []event {
+				return []event{
+					{"sunset", night.Sunset, "2024-12-21T21:32:00Z"},
+					{"sunrise", night.Sunrise, "2024-12-22T12:17:00Z"},
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		setTestSite(tt.latitude, tt.longitude, tt.timezone)
+		for _, e := range tt.events(nightEvents(mustParseTime(t, tt.time))) {
+			switch {
+			case e.want == "" && e.got != nil:
+				t.Errorf("%s: %s at %v, want none", tt.name, e.name, e.got)
+			case e.want == "":
+			case e.got == nil:
+				t.Errorf("%s: no %s, want %s", tt.name, e.name, e.want)
+			default:
+				if diff := e.got.Sub(mustParseTime(t, e.want)); diff.Abs() > 2*time.Minute {
+					t.Errorf("%s: %s at %v, want %s", tt.name, e.name, e.got, e.want)
+				}
+			}
+		}
+	}
+}
+
+func TestTwilightState(t *testing.T) {
+	config = Config{}
+	tests := []struct {
+		altitude float64
+		want     string
+		daylight string
+	}{
+		{10, "Daylight", "Very Light"},
+		{-0.5, "Daylight", "Very Light"},
+		{-3, "Civil Twilight", "Light"},
+		{-9, "Nautical Twilight", "Dark"},
+		{-15, "Astronomical Twilight", "Dark"},
+		{-30, "Night", "Dark"},
+	}
+	for _, tt := range tests {
+		if got := twilightState(tt.altitude); got != tt.want {
+			t.Errorf("twilightState(%v) = %q, want %q", tt.altitude, got, tt.want)
+		}
+		if got := parseDaylightCondition(daylightCode(tt.altitude)); got != tt.daylight {
+			t.Errorf("daylight condition at %v° = %q, want %q", tt.altitude, got, tt.daylight)
+		}
+	}
+}
+
+func TestDeriveEphemeris(t *testing.T) {
+	setTestSite(51.4779, 0, "Europe/London")
+	at := mustParseTime(t, "2024-12-21T18:00:00Z")
+	data := WeatherData{Sensors: map[string]SensorInfo{}}
+	deriveEphemeris(&data, at)
+
+	want := computeEphemeris(at)
+	if data.SunAltitude != want.Sun.Altitude || data.MoonAltitude != want.Moon.Altitude ||
+		data.MoonIllumination != want.Moon.Illumination || data.DaylightCondition != want.DaylightCondition {
+		t.Errorf("derived sun %v°, moon %v° %v%% lit, %q; want %+v", data.SunAltitude, data.MoonAltitude, data.MoonIllumination, data.DaylightCondition, want)
+	}
+	if info := data.Sensors["sunAltitude"]; info.Source != "derived" || !info.Updated.Equal(at) {
+		t.Errorf("sunAltitude sensor %+v", info)
+	}
+
+	// Nothing is derived without a site
+	config = Config{}
+	data = WeatherData{Sensors: map[string]SensorInfo{}}
+	deriveEphemeris(&data, at)
+	if len(data.Sensors) != 0 {
+		t.Errorf("derived %v without a site", data.Sensors)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportOptions controls the format and units of exported history
+type exportOptions struct {
+	Format   string   // csv, json or boltwood
+	Columns  []string // CSV columns; defaults to every field
+	TempUnit string   // C or F
+	WindUnit string   // m/s, km/h or mph
+}
+
+// Fields converted by the CSV temperature and wind speed units
+var temperatureFields = map[string]bool{
+	"skyTemperature":     true,
+	"ambientTemperature": true,
+	"sensorTemperature":  true,
+	"dewPoint":           true,
+	"calculatedDewPoint": true,
+	"windChill":          true,
+}
+
+// Temperature differences and rates, converted without the offset
+var temperatureDifferenceFields = map[string]bool{
+	"dewPointDepression":      true,
+	"skyAmbientDeltaTrend":    true,
+	"dewPointDepressionTrend": true,
+}
+
+var windSpeedFields = map[string]bool{
+	"windSpeed": true,
+}
+
+func (o *exportOptions) validate() error {
+	switch o.Format {
+	case "csv", "json", "boltwood":
+	case "":
+		o.Format = "csv"
+	default:
+		return fmt.Errorf("unknown export format %q", o.Format)
+	}
+
+	switch strings.ToUpper(o.TempUnit) {
+	case "", "C":
+		o.TempUnit = "C"
+	case "F":
+		o.TempUnit = "F"
+	default:
+		return fmt.Errorf("unknown temperature unit %q", o.TempUnit)
+	}
+
+	switch strings.ToLower(o.WindUnit) {
+	case "", "m/s", "ms":
+		o.WindUnit = "m/s"
+	case "km/h", "kmh":
+		o.WindUnit = "km/h"
+	case "mph":
+		o.WindUnit = "mph"
+	default:
+		return fmt.Errorf("unknown wind speed unit %q", o.WindUnit)
+	}
+
+	if len(o.Columns) == 0 {
+		o.Columns = append([]string{"date"}, weatherFieldKeys...)
+	}
+	for _, column := range o.Columns {
+		if column != "date" && !isWeatherField(column) {
+			return fmt.Errorf("unknown column %q", column)
+		}
+	}
+	return nil
+}
+
+// writeExport writes samples to w in the requested format, flushing as it goes
+func writeExport(w io.Writer, samples []WeatherData, options exportOptions) error {
+	switch options.Format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		for _, sample := range samples {
+			if err := encoder.Encode(sample); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "boltwood":
+		writer := bufio.NewWriter(w)
+		for _, sample := range samples {
+			if _, err := fmt.Fprintf(writer, "%s\r\n", formatBoltwoodLine(sample, 0)); err != nil {
+				return err
+			}
+		}
+		return writer.Flush()
+
+	default:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(options.Columns); err != nil {
+			return err
+		}
+		for i := range samples {
+			if err := writer.Write(csvRecord(&samples[i], options)); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+}
+
+func csvRecord(sample *WeatherData, options exportOptions) []string {
+	record := make([]string, len(options.Columns))
+	for i, column := range options.Columns {
+		if column == "date" {
+			record[i] = sample.Date.UTC().Format(time.RFC3339)
+			continue
+		}
+
+		value, ok := weatherFieldValue(sample, column)
+		if !ok {
+			record[i], _ = weatherFieldString(sample, column)
+			continue
+		}
+		if temperatureFields[column] && options.TempUnit == "F" {
+			value = value*9/5 + 32
+		}
+		if temperatureDifferenceFields[column] && options.TempUnit == "F" {
+			value = value * 9 / 5
+		}
+		if windSpeedFields[column] {
+			value = convertWindSpeedTo(value, options.WindUnit)
+		}
+		record[i] = strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64)
+	}
+	return record
+}
+
+// convertWindSpeedTo converts a speed in m/s to the named unit
+func convertWindSpeedTo(speed float64, unit string) float64 {
+	switch unit {
+	case "km/h":
+		return speed * 3.6
+	case "mph":
+		return speed / 0.44704
+	default:
+		return speed
+	}
+}
+
+// conditionCode maps a condition string back to its Boltwood code, the
+// inverse of the parseXxxCondition functions. Unknown conditions map to 0.
+func conditionCode(parse func(int) string, condition string) int {
+	for code := 0; code <= 3; code++ {
+		if parse(code) == condition {
+			return code
+		}
+	}
+	return 0
+}
+
+// vb6Date returns t as a Visual Basic date: days since 1899-12-30 local time
+func vb6Date(t time.Time) float64 {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	return wall.Sub(time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)).Hours() / 24
+}
+
+// formatBoltwoodLine renders a sample as a Boltwood II one-line data file
+// entry in the configured timezone, readable by parseBoltwoodData. since is
+// the seconds elapsed since the data was last valid.
+func formatBoltwoodLine(data WeatherData, since int) string {
+	loc, err := time.LoadLocation(config.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	date := data.Date.In(loc)
+
+	return fmt.Sprintf("%s C M %6.1f %6.1f %6.1f %6.1f %3.0f %6.1f %3.0f %d %d %05d %012.5f %d %d %d %d %d %d",
+		date.Format("2006-01-02 15:04:05.00"),
+		data.SkyTemperature,
+		data.AmbientTemperature,
+		data.SensorTemperature,
+		data.WindSpeed/0.44704, // m/s to mph
+		data.Humidity,
+		data.DewPoint,
+		data.DewHeaterPercentage,
+		data.RainFlag,
+		data.WetFlag,
+		since,
+		vb6Date(date),
+		conditionCode(parseCloudCondition, data.CloudCondition),
+		conditionCode(parseWindCondition, data.WindCondition),
+		conditionCode(parseRainCondition, data.RainCondition),
+		conditionCode(parseDarknessCondition, data.DarknessCondition),
+		data.RoofCloseFlag,
+		conditionCode(parseAlertStatus, data.AlertStatus),
+	)
+}
+
+func handleExportAPI(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseHistoryRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	options := exportOptions{
+		Format:   r.URL.Query().Get("format"),
+		TempUnit: r.URL.Query().Get("tempUnit"),
+		WindUnit: r.URL.Query().Get("windUnit"),
+	}
+	if columns := r.URL.Query().Get("columns"); columns != "" {
+		options.Columns = strings.Split(columns, ",")
+	}
+	if err := options.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	contentTypes := map[string]string{
+		"csv":      "text/csv",
+		"json":     "application/x-ndjson",
+		"boltwood": "text/plain",
+	}
+	extensions := map[string]string{"csv": "csv", "json": "jsonl", "boltwood": "txt"}
+	w.Header().Set("Content-Type", contentTypes[options.Format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=weather-%s.%s",
+		from.UTC().Format("20060102T150405Z"), extensions[options.Format]))
+
+	if err := writeExport(w, queryHistory(from, to), options); err != nil {
+		// Too late for an error status once rows have been written
+		log.Printf("Error writing export: %v", err)
+	}
+}
+
+// runExportCommand implements the "export" subcommand, writing history from
+// the on-disk store without starting the server
+func runExportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	options := exportOptions{}
+	flags.StringVar(&options.Format, "format", "csv", "Export format: csv, json or boltwood")
+	flags.StringVar(&options.TempUnit, "temp", "C", "CSV temperature unit: C or F")
+	flags.StringVar(&options.WindUnit, "wind", "m/s", "CSV wind speed unit: m/s, km/h or mph")
+	columns := flags.String("columns", "", "Comma separated CSV columns (default all)")
+	fromStr := flags.String("from", "24h", "Start time: RFC3339, Unix seconds or a duration ago")
+	toStr := flags.String("to", "0s", "End time: RFC3339, Unix seconds or a duration ago")
+	output := flags.String("o", "", "Output file (default stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *columns != "" {
+		options.Columns = strings.Split(*columns, ",")
+	}
+	if err := options.validate(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	from, err := parseHistoryTime(*fromStr, now)
+	if err != nil {
+		return err
+	}
+	to, err := parseHistoryTime(*toStr, now)
+	if err != nil {
+		return err
+	}
+
+	if config.HistoryDir == "" {
+		return fmt.Errorf("HistoryDir is not specified in the config file")
+	}
+	samples, err := (&historyStore{dir: config.HistoryDir}).query(from, to)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	return writeExport(out, samples, options)
+}
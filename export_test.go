@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBoltwoodLineRoundTrip(t *testing.T) {
+	sample := WeatherData{
+		Date:                time.Date(2024, 6, 21, 22, 15, 30, 0, time.UTC),
+		SkyTemperature:      -25.3,
+		AmbientTemperature:  12.4,
+		SensorTemperature:   13.1,
+		WindSpeed:           4.2,
+		Humidity:            76,
+		DewPoint:            8.2,
+		DewHeaterPercentage: 12,
+		RainFlag:            0,
+		WetFlag:             1,
+		RoofCloseFlag:       1,
+		CloudCondition:      "Light Clouds",
+		WindCondition:       "Windy",
+		RainCondition:       "Damp",
+		DarknessCondition:   "Dark",
+		AlertStatus:         "Alert",
+	}
+
+	for _, timezone := range []string{"UTC", "America/New_York", "Australia/Sydney"} {
+		t.Run(timezone, func(t *testing.T) {
+			config = Config{Timezone: timezone}
+			line := formatBoltwoodLine(sample, 7)
+			if fields := strings.Fields(line); len(fields) != 21 {
+				t.Fatalf("line has %d fields, want 21: %q", len(fields), line)
+			}
+
+			got, err := parseBoltwoodData([]byte(line + "\r\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Date.Equal(sample.Date) {
+				t.Errorf("date = %v, want %v", got.Date, sample.Date)
+			}
+			for _, key := range boltwoodFields {
+				want, numeric := weatherFieldValue(&sample, key)
+				if !numeric {
+					wantString, _ := weatherFieldString(&sample, key)
+					if gotString, _ := weatherFieldString(&got, key); gotString != wantString {
+						t.Errorf("%s = %q, want %q", key, gotString, wantString)
+					}
+					continue
+				}
+				// Wind goes through mph with one decimal
+				if value, _ := weatherFieldValue(&got, key); math.Abs(value-want) > 0.03 {
+					t.Errorf("%s = %v, want %v", key, value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseBoltwoodDataErrors(t *testing.T) {
+	config = Config{Timezone: "UTC"}
+	tests := map[string]string{
+		"two lines":    "2024-06-21 22:15:30.00 C K -25.3\n2024-06-21 22:15:30.00 C K -25.3\n",
+		"short line":   "2024-06-21 22:15:30.00 C K -25.3 12.4",
+		"invalid date": "21/06/2024 22:15:30 C M -25.3 12.4 13.1 9.4 76 8.2 12 0 1 00007 045464.92743 2 2 2 1 1 1",
+	}
+	for name, data := range tests {
+		if _, err := parseBoltwoodData([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestVB6Date(t *testing.T) {
+	tests := []struct {
+		t    time.Time
+		want float64
+	}{
+		{time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC), 0},
+		{time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC), 36526.5},
+		// Local wall clock time, not UTC
+		{time.Date(2000, 1, 1, 6, 0, 0, 0, time.FixedZone("CST", -6*3600)), 36526.25},
+	}
+	for _, tt := range tests {
+		if got := vb6Date(tt.t); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("vb6Date(%v) = %v, want %v", tt.t, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExporterConfig describes a push exporter sending each new sample to a
+// time-series database
+type ExporterConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // influxdb or graphite
+	URL  string `json:"url"`  // InfluxDB base URL, or host:port for Graphite
+
+	// InfluxDB v1 uses Database (and optional Username/Password); v2 is
+	// selected by setting Bucket, with Org and Token
+	Database    string            `json:"database"`
+	Username    string            `json:"username"`
+	Password    string            `json:"password"`
+	Org         string            `json:"org"`
+	Bucket      string            `json:"bucket"`
+	Token       string            `json:"token"`
+	Measurement string            `json:"measurement"`
+	Tags        map[string]string `json:"tags"`
+
+	// Graphite metric path prefix
+	Prefix string `json:"prefix"`
+
+	BatchSize     int    `json:"batchSize"`
+	FlushInterval string `json:"flushInterval"`
+	MaxBackoff    string `json:"maxBackoff"`
+	BufferDir     string `json:"bufferDir"` // Spool directory while the sink is unreachable
+}
+
+// pushExporter batches formatted lines and sends them to its sink, spooling
+// to disk (or memory without a BufferDir) and backing off while it fails
+type pushExporter struct {
+	config      ExporterConfig
+	format      func(WeatherData) []string
+	send        func(lines []string) error
+	samples     chan WeatherData
+	pending     []string
+	spool       []string // In-memory spool when BufferDir is not set
+	backoff     time.Duration
+	nextAttempt time.Time
+}
+
+const (
+	exporterQueueSize   = 100
+	maxMemorySpoolLines = 10000
+	spoolChunkLines     = 5000
+)
+
+func validateExporters() error {
+	names := make(map[string]bool)
+	for i := range config.Exporters {
+		exporter := &config.Exporters[i]
+		if exporter.Name == "" {
+			exporter.Name = fmt.Sprintf("%s-%d", exporter.Type, i)
+		}
+		if names[exporter.Name] {
+			return fmt.Errorf("duplicate exporter name %q", exporter.Name)
+		}
+		names[exporter.Name] = true
+
+		switch exporter.Type {
+		case "influxdb":
+			if exporter.Database == "" && exporter.Bucket == "" {
+				return fmt.Errorf("exporter %s needs a database (v1) or bucket (v2)", exporter.Name)
+			}
+			if exporter.Measurement == "" {
+				exporter.Measurement = "weather"
+			}
+		case "graphite":
+			if exporter.Prefix == "" {
+				exporter.Prefix = "observatory.weather"
+			}
+		default:
+			return fmt.Errorf("exporter %s has unknown type %q", exporter.Name, exporter.Type)
+		}
+		if exporter.URL == "" {
+			return fmt.Errorf("exporter %s has no url", exporter.Name)
+		}
+
+		if exporter.BatchSize <= 0 {
+			exporter.BatchSize = 10
+		}
+		for _, setting := range []struct {
+			name  string
+			value *string
+			def   string
+		}{
+			{"FlushInterval", &exporter.FlushInterval, "10s"},
+			{"MaxBackoff", &exporter.MaxBackoff, "5m"},
+		} {
+			if *setting.value == "" {
+				*setting.value = setting.def
+			}
+			duration, err := time.ParseDuration(*setting.value)
+			if err != nil || duration <= 0 {
+				return fmt.Errorf("invalid %s for exporter %s: %q", setting.name, exporter.Name, *setting.value)
+			}
+			*setting.value = duration.String()
+		}
+	}
+	return nil
+}
+
+// initExporters starts a goroutine per configured exporter
+func initExporters() {
+	for _, exporterConfig := range config.Exporters {
+		exporter := &pushExporter{
+			config:  exporterConfig,
+			samples: make(chan WeatherData, exporterQueueSize),
+		}
+		switch exporterConfig.Type {
+		case "influxdb":
+			exporter.format = exporter.influxLines
+			exporter.send = exporter.sendInflux
+		case "graphite":
+			exporter.format = exporter.graphiteLines
+			exporter.send = exporter.sendGraphite
+		}
+
+		onWeatherUpdate(func(data WeatherData) {
+			select {
+			case exporter.samples <- data:
+			default:
+				log.Printf("Exporter %s queue full, dropping sample", exporter.config.Name)
+			}
+		})
+		go exporter.run()
+	}
+}
+
+func (e *pushExporter) run() {
+	interval, _ := time.ParseDuration(e.config.FlushInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case data := <-e.samples:
+			e.pending = append(e.pending, e.format(data)...)
+			if len(e.pending) >= e.config.BatchSize {
+				e.flush()
+			}
+		case <-ticker.C:
+			e.flush()
+		}
+	}
+}
+
+// flush sends spooled lines oldest first, then the pending batch. On failure
+// the batch is spooled and sending is retried after an exponential backoff.
+func (e *pushExporter) flush() {
+	if len(e.pending) == 0 && !e.hasSpool() {
+		return
+	}
+	if time.Now().Before(e.nextAttempt) {
+		e.spoolLines()
+		return
+	}
+
+	err := e.drainSpool()
+	if err == nil && len(e.pending) > 0 {
+		err = e.send(e.pending)
+	}
+	if err != nil {
+		e.spoolLines()
+		maxBackoff, _ := time.ParseDuration(e.config.MaxBackoff)
+		e.backoff = min(max(2*e.backoff, time.Second), maxBackoff)
+		e.nextAttempt = time.Now().Add(e.backoff)
+		log.Printf("Exporter %s failed, retrying in %s: %v", e.config.Name, e.backoff, redactText(err.Error()))
+		return
+	}
+
+	if e.backoff > 0 {
+		log.Printf("Exporter %s recovered", e.config.Name)
+	}
+	e.pending = nil
+	e.backoff = 0
+}
+
+func (e *pushExporter) spoolPath() string {
+	return filepath.Join(e.config.BufferDir, e.config.Name+".spool")
+}
+
+func (e *pushExporter) hasSpool() bool {
+	if e.config.BufferDir == "" {
+		return len(e.spool) > 0
+	}
+	info, err := os.Stat(e.spoolPath())
+	return err == nil && info.Size() > 0
+}
+
+// spoolLines moves the pending batch to the spool
+func (e *pushExporter) spoolLines() {
+	if len(e.pending) == 0 {
+		return
+	}
+	defer func() { e.pending = nil }()
+
+	if e.config.BufferDir == "" {
+		e.spool = append(e.spool, e.pending...)
+		if len(e.spool) > maxMemorySpoolLines {
+			e.spool = e.spool[len(e.spool)-maxMemorySpoolLines:]
+		}
+		return
+	}
+
+	if err := os.MkdirAll(e.config.BufferDir, 0755); err != nil {
+		log.Printf("Error creating exporter buffer directory: %v", err)
+		return
+	}
+	file, err := os.OpenFile(e.spoolPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("Error opening exporter spool: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.WriteString(strings.Join(e.pending, "\n") + "\n"); err != nil {
+		log.Printf("Error writing exporter spool: %v", err)
+	}
+}
+
+// drainSpool sends spooled lines in chunks, keeping whatever is not sent
+func (e *pushExporter) drainSpool() error {
+	if e.config.BufferDir == "" {
+		for len(e.spool) > 0 {
+			n := min(len(e.spool), spoolChunkLines)
+			if err := e.send(e.spool[:n]); err != nil {
+				return err
+			}
+			e.spool = e.spool[n:]
+		}
+		return nil
+	}
+
+	data, err := os.ReadFile(e.spoolPath())
+	if os.IsNotExist(err) || len(data) == 0 {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	for len(lines) > 0 {
+		n := min(len(lines), spoolChunkLines)
+		if err := e.send(lines[:n]); err != nil {
+			if rewriteErr := os.WriteFile(e.spoolPath(), []byte(strings.Join(lines, "\n")+"\n"), 0644); rewriteErr != nil {
+				log.Printf("Error rewriting exporter spool: %v", rewriteErr)
+			}
+			return err
+		}
+		lines = lines[n:]
+	}
+	return os.Remove(e.spoolPath())
+}
+
+// influxLines formats a sample as one InfluxDB line protocol point
+func (e *pushExporter) influxLines(data WeatherData) []string {
+	var fields []string
+	for _, key := range weatherFieldKeys {
+		if _, ok := data.Sensors[key]; !ok {
+			continue
+		}
+		if value, ok := weatherFieldValue(&data, key); ok {
+			fields = append(fields, fmt.Sprintf("%s=%s", escapeInflux(key), strconv.FormatFloat(value, 'f', -1, 64)))
+		} else {
+			value, _ := weatherFieldString(&data, key)
+			fields = append(fields, fmt.Sprintf(`%s="%s"`, escapeInflux(key), escapeInfluxString(value)))
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	series := escapeInflux(e.config.Measurement)
+	tagKeys := make([]string, 0, len(e.config.Tags))
+	for key := range e.config.Tags {
+		tagKeys = append(tagKeys, key)
+	}
+	sort.Strings(tagKeys)
+	for _, key := range tagKeys {
+		series += "," + escapeInflux(key) + "=" + escapeInflux(e.config.Tags[key])
+	}
+
+	return []string{fmt.Sprintf("%s %s %d", series, strings.Join(fields, ","), data.Date.Unix())}
+}
+
+func escapeInflux(s string) string {
+	return strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`).Replace(s)
+}
+
+// escapeInfluxString escapes a string field value, in which line protocol
+// only treats double quotes and backslashes specially
+func escapeInfluxString(s string) string {
+	return strings.NewReplacer(`"`, `\"`, `\`, `\\`).Replace(s)
+}
+
+func (e *pushExporter) sendInflux(lines []string) error {
+	base := strings.TrimRight(e.config.URL, "/")
+	query := url.Values{"precision": {"s"}}
+	var endpoint string
+	if e.config.Bucket != "" {
+		endpoint = base + "/api/v2/write"
+		query.Set("org", e.config.Org)
+		query.Set("bucket", e.config.Bucket)
+	} else {
+		endpoint = base + "/write"
+		query.Set("db", e.config.Database)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint+"?"+query.Encode(), strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if e.config.Token != "" {
+		req.Header.Set("Authorization", "Token "+e.config.Token)
+	} else if e.config.Username != "" {
+		req.SetBasicAuth(e.config.Username, e.config.Password)
+	}
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("InfluxDB returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// graphiteLines formats the numeric fields of a sample in Graphite plaintext
+func (e *pushExporter) graphiteLines(data WeatherData) []string {
+	var lines []string
+	for _, key := range weatherFieldKeys {
+		if _, ok := data.Sensors[key]; !ok {
+			continue
+		}
+		if value, ok := weatherFieldValue(&data, key); ok {
+			lines = append(lines, fmt.Sprintf("%s.%s %s %d", e.config.Prefix, key,
+				strconv.FormatFloat(value, 'f', -1, 64), data.Date.Unix()))
+		}
+	}
+	return lines
+}
+
+func (e *pushExporter) sendGraphite(lines []string) error {
+	conn, err := net.DialTimeout("tcp", e.config.URL, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	writer := bufio.NewWriter(conn)
+	for _, line := range lines {
+		if _, err := writer.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func exporterSample() WeatherData {
+	date := time.Unix(1719007200, 0).UTC()
+	return WeatherData{
+		Date:           date,
+		SkyTemperature: -21.5,
+		Humidity:       64,
+		CloudCondition: `Clear "°" \`,
+		Sensors: map[string]SensorInfo{
+			"skyTemperature": {Updated: date},
+			"humidity":       {Updated: date},
+			"cloudCondition": {Updated: date},
+		},
+	}
+}
+
+func TestInfluxLines(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  ExporterConfig
+		want string
+	}{
+		{
+			name: "plain",
+			cfg:  ExporterConfig{Measurement: "weather"},
+			want: `weather skyTemperature=-21.5,humidity=64,cloudCondition="Clear \"°\" \\" 1719007200`,
+		},
+		{
+			name: "escaped tags",
+			cfg:  ExporterConfig{Measurement: "sky weather", Tags: map[string]string{"site": "back yard", "a=b": "c,d"}},
+			want: `sky\ weather,a\=b=c\,d,site=back\ yard skyTemperature=-21.5,humidity=64,cloudCondition="Clear \"°\" \\" 1719007200`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &pushExporter{config: tt.cfg}
+			lines := e.influxLines(exporterSample())
+			if len(lines) != 1 || lines[0] != tt.want {
+				t.Errorf("got %q\nwant %q", lines, tt.want)
+			}
+		})
+	}
+
+	if lines := (&pushExporter{}).influxLines(WeatherData{}); lines != nil {
+		t.Errorf("sample without sensors gave %q, want no lines", lines)
+	}
+}
+
+func TestEscapeInfluxString(t *testing.T) {
+	tests := map[string]string{
+		"Very Cloudy":  "Very Cloudy",
+		`say "hi"`:     `say \"hi\"`,
+		`C:\data`:      `C:\\data`,
+		"tab\there °C": "tab\there °C",
+	}
+	for value, want := range tests {
+		if got := escapeInfluxString(value); got != want {
+			t.Errorf("escapeInfluxString(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestGraphiteLines(t *testing.T) {
+	e := &pushExporter{config: ExporterConfig{Prefix: "obs.weather"}}
+	got := e.graphiteLines(exporterSample())
+	want := []string{"obs.weather.skyTemperature -21.5 1719007200", "obs.weather.humidity 64 1719007200"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSendInflux(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       ExporterConfig
+		wantPath  string
+		wantQuery string
+		wantAuth  string
+	}{
+		{
+			name:      "v1",
+			cfg:       ExporterConfig{Database: "obs", Username: "u", Password: "p"},
+			wantPath:  "/write",
+			wantQuery: "db=obs&precision=s",
+			wantAuth:  "Basic dTpw",
+		},
+		{
+			name:      "v2",
+			cfg:       ExporterConfig{Org: "home", Bucket: "weather", Token: "secret"},
+			wantPath:  "/api/v2/write",
+			wantQuery: "bucket=weather&org=home&precision=s",
+			wantAuth:  "Token secret",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path, query, auth, body string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				path, query, auth, body = r.URL.Path, r.URL.RawQuery, r.Header.Get("Authorization"), string(data)
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer server.Close()
+
+			tt.cfg.URL = server.URL + "/"
+			e := &pushExporter{config: tt.cfg}
+			if err := e.sendInflux([]string{"a x=1 1", "a x=2 2"}); err != nil {
+				t.Fatal(err)
+			}
+			if path != tt.wantPath || query != tt.wantQuery || auth != tt.wantAuth {
+				t.Errorf("request %s?%s auth %q, want %s?%s auth %q", path, query, auth, tt.wantPath, tt.wantQuery, tt.wantAuth)
+			}
+			if body != "a x=1 1\na x=2 2" {
+				t.Errorf("body = %q", body)
+			}
+		})
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "database not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+	e := &pushExporter{config: ExporterConfig{URL: server.URL, Database: "obs"}}
+	if err := e.sendInflux([]string{"a x=1 1"}); err == nil || !strings.Contains(err.Error(), "database not found") {
+		t.Errorf("error = %v, want the server's message", err)
+	}
+}
+
+func TestSendGraphite(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		received <- lines
+	}()
+
+	e := &pushExporter{config: ExporterConfig{URL: listener.Addr().String()}}
+	if err := e.sendGraphite([]string{"a.b 1 10", "a.c 2 10"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case lines := <-received:
+		if strings.Join(lines, "|") != "a.b 1 10|a.c 2 10" {
+			t.Errorf("received %q", lines)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no connection")
+	}
+
+	listener.Close()
+	if err := e.sendGraphite([]string{"a.b 1 10"}); err == nil {
+		t.Error("expected an error with the listener closed")
+	}
+}
+
+// influxStub accepts writes while up, recording each request body
+type influxStub struct {
+	mu     sync.Mutex
+	up     bool
+	bodies []string
+}
+
+func (s *influxStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.up {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	data, _ := io.ReadAll(r.Body)
+	s.bodies = append(s.bodies, string(data))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestExporterSpoolAndBackoff(t *testing.T) {
+	for _, bufferDir := range []string{"", t.TempDir()} {
+		name := "memory"
+		if bufferDir != "" {
+			name = "disk"
+		}
+		t.Run(name, func(t *testing.T) {
+			stub := &influxStub{}
+			server := httptest.NewServer(stub)
+			defer server.Close()
+
+			e := &pushExporter{config: ExporterConfig{
+				Name: "test", URL: server.URL, Database: "obs", MaxBackoff: "3s", BufferDir: bufferDir,
+			}}
+			e.send = e.sendInflux
+
+			// Failures spool the batch and back off exponentially up to MaxBackoff
+			for i, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+				e.pending = []string{"a x=" + string(rune('1'+i)) + " 1"}
+				e.nextAttempt = time.Time{}
+				e.flush()
+				if e.backoff != want {
+					t.Errorf("backoff after failure %d = %s, want %s", i+1, e.backoff, want)
+				}
+				if !e.hasSpool() || e.pending != nil {
+					t.Fatalf("batch %d not spooled", i+1)
+				}
+			}
+
+			// Within the backoff nothing is sent
+			stub.up = true
+			e.pending = []string{"a x=4 1"}
+			e.flush()
+			if len(stub.bodies) != 0 {
+				t.Fatalf("sent %q during backoff", stub.bodies)
+			}
+
+			// Once it expires the spool drains oldest first, then the new batch
+			e.nextAttempt = time.Time{}
+			e.pending = []string{"a x=5 1"}
+			e.flush()
+			if got := strings.Join(stub.bodies, "|"); got != "a x=1 1\na x=2 1\na x=3 1\na x=4 1|a x=5 1" {
+				t.Errorf("sent %q", got)
+			}
+			if e.hasSpool() || e.backoff != 0 {
+				t.Errorf("spool or backoff left after recovery")
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// FilterConfig configures the outlier filter of one numeric field, applied
+// to each source's readings before they are fused. A reading outside Min and
+// Max, or changing faster than MaxChangePerMinute since the last accepted
+// reading, is rejected and the last accepted value kept. Median smooths the
+// accepted readings over a window of that many samples.
+type FilterConfig struct {
+	Min                *float64 `json:"min"`
+	Max                *float64 `json:"max"`
+	MaxChangePerMinute *float64 `json:"maxChangePerMinute"`
+	Median             int      `json:"median"`
+}
+
+// fieldFilter is the state of one field's filter for one source
+type fieldFilter struct {
+	window []float64 // accepted raw values, newest last
+
+	accepted     float64 // last accepted raw value
+	acceptedTime time.Time
+	output       float64 // last published value
+	hasOutput    bool
+
+	// The last input, so that re-reading an unchanged data file is not
+	// counted as new samples
+	inputDate     time.Time
+	inputValue    float64
+	inputRejected bool
+}
+
+var (
+	fieldFilters = make(map[string]map[string]*fieldFilter) // by source, then field
+	filterMutex  sync.Mutex
+)
+
+func validateFilters() error {
+	var data WeatherData
+	for key, filter := range config.Filters {
+		if _, ok := weatherFieldValue(&data, key); !ok || !isSourceField(key) {
+			return fmt.Errorf("unknown numeric field %q in Filters", key)
+		}
+		if filter.Min != nil && filter.Max != nil && *filter.Min >= *filter.Max {
+			return fmt.Errorf("Filters.%s.min must be below max", key)
+		}
+		if filter.MaxChangePerMinute != nil && *filter.MaxChangePerMinute <= 0 {
+			return fmt.Errorf("Filters.%s.maxChangePerMinute must be positive", key)
+		}
+		if filter.Median < 0 || (filter.Median > 0 && filter.Median%2 == 0) {
+			return fmt.Errorf("Filters.%s.median must be an odd number of samples", key)
+		}
+	}
+	return nil
+}
+
+// filterReading applies the configured filters to a source's new reading.
+// Values replaced by the filter are kept in the reading's Raw map, rejected
+// fields are listed in Rejected, and the values held in their place are dated
+// in Held so that they still age out.
+func filterReading(source string, reading *sourceReading) {
+	if len(config.Filters) == 0 {
+		return
+	}
+	filterMutex.Lock()
+	defer filterMutex.Unlock()
+
+	filters := fieldFilters[source]
+	if filters == nil {
+		filters = make(map[string]*fieldFilter)
+		fieldFilters[source] = filters
+	}
+
+	var fields []string
+	for _, key := range reading.Fields {
+		filterConfig, ok := config.Filters[key]
+		if !ok {
+			fields = append(fields, key)
+			continue
+		}
+		f := filters[key]
+		if f == nil {
+			f = &fieldFilter{}
+			filters[key] = f
+		}
+
+		raw, _ := weatherFieldValue(&reading.Data, key)
+		value, rejected, keep := f.apply(source, key, filterConfig, raw, reading.Data.Date)
+		if rejected {
+			reading.Data.Rejected = append(reading.Data.Rejected, key)
+		}
+		if !keep {
+			// Nothing accepted yet to stand in for the rejected value
+			continue
+		}
+		if rejected {
+			if reading.Held == nil {
+				reading.Held = make(map[string]time.Time)
+			}
+			reading.Held[key] = f.acceptedTime
+		}
+		fields = append(fields, key)
+		if value != raw {
+			if reading.Data.Raw == nil {
+				reading.Data.Raw = make(map[string]float64)
+			}
+			reading.Data.Raw[key] = raw
+			setWeatherFieldValue(&reading.Data, key, value)
+		}
+	}
+	reading.Fields = fields
+}
+
+// apply filters one value, returning the value to publish, whether the input
+// was rejected, and false when there is no value to publish
+func (f *fieldFilter) apply(source, key string, c FilterConfig, value float64, date time.Time) (float64, bool, bool) {
+	if date.Equal(f.inputDate) && value == f.inputValue {
+		return f.output, f.inputRejected, f.hasOutput
+	}
+	f.inputDate, f.inputValue = date, value
+
+	reason := ""
+	switch {
+	case c.Min != nil && value < *c.Min:
+		reason = fmt.Sprintf("below minimum %g", *c.Min)
+	case c.Max != nil && value > *c.Max:
+		reason = fmt.Sprintf("above maximum %g", *c.Max)
+	case c.MaxChangePerMinute != nil && f.hasOutput:
+		minutes := math.Max(date.Sub(f.acceptedTime).Minutes(), 1.0/60)
+		if rate := math.Abs(value-f.accepted) / minutes; rate > *c.MaxChangePerMinute {
+			reason = fmt.Sprintf("changed by %.2f/min from %g", rate, f.accepted)
+		}
+	}
+	f.inputRejected = reason != ""
+	if f.inputRejected {
+		log.Printf("Rejected %s %g from %s: %s", key, value, source, reason)
+		recordRejection(source, key)
+		return f.output, true, f.hasOutput
+	}
+
+	f.accepted, f.acceptedTime = value, date
+	f.output, f.hasOutput = value, true
+	if c.Median > 1 {
+		f.window = append(f.window, value)
+		if len(f.window) > c.Median {
+			f.window = f.window[1:]
+		}
+		f.output = medianValue(f.window)
+	}
+	return f.output, false, true
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func float64Ptr(v float64) *float64 { return &v }
+
+func TestFieldFilterApply(t *testing.T) {
+	start := time.Date(2024, 6, 21, 22, 0, 0, 0, time.UTC)
+	type input struct {
+		minute       int
+		value        float64
+		want         float64
+		wantRejected bool
+		wantKeep     bool
+	}
+	tests := []struct {
+		name   string
+		config FilterConfig
+		inputs []input
+	}{
+		{
+			name:   "range",
+			config: FilterConfig{Min: float64Ptr(0), Max: float64Ptr(100)},
+			inputs: []input{
+				{0, 120, 0, true, false}, // nothing accepted yet
+				{1, 50, 50, false, true},
+				{2, -1, 50, true, true},
+				{3, 101, 50, true, true},
+				{4, 100, 100, false, true},
+			},
+		},
+		{
+			name:   "rate of change",
+			config: FilterConfig{MaxChangePerMinute: float64Ptr(2)},
+			inputs: []input{
+				{0, 10, 10, false, true},
+				{1, 11.5, 11.5, false, true},
+				{2, 20, 11.5, true, true},
+				// Measured against the last accepted reading, three minutes before
+				{4, 16, 16, false, true},
+			},
+		},
+		{
+			name:   "median",
+			config: FilterConfig{Median: 3, Max: float64Ptr(50)},
+			inputs: []input{
+				{0, 10, 10, false, true},
+				{1, 30, 20, false, true},
+				{2, 12, 12, false, true},
+				{3, 99, 12, true, true},
+				{4, 14, 14, false, true},
+				{5, 40, 14, false, true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fieldFilter{}
+			for _, in := range tt.inputs {
+				date := start.Add(time.Duration(in.minute) * time.Minute)
+				value, rejected, keep := f.apply("test", "humidity", tt.config, in.value, date)
+				if keep != in.wantKeep || rejected != in.wantRejected || (keep && value != in.want) {
+					t.Errorf("minute %d input %v: got %v, rejected %v, keep %v; want %v, %v, %v",
+						in.minute, in.value, value, rejected, keep, in.want, in.wantRejected, in.wantKeep)
+				}
+			}
+		})
+	}
+}
+
+func TestFieldFilterRepeatedInput(t *testing.T) {
+	date := time.Date(2024, 6, 21, 22, 0, 0, 0, time.UTC)
+	f := &fieldFilter{}
+	c := FilterConfig{Median: 3}
+	f.apply("test", "humidity", c, 10, date)
+	f.apply("test", "humidity", c, 30, date.Add(time.Minute))
+
+	// Re-reading an unchanged file does not add samples to the median window
+	for i := 0; i < 3; i++ {
+		f.apply("test", "humidity", c, 30, date.Add(time.Minute))
+	}
+	if len(f.window) != 2 {
+		t.Errorf("window = %v after repeated input, want 2 samples", f.window)
+	}
+}
+
+func TestFilteredFieldAgesOut(t *testing.T) {
+	config = Config{
+		Sources:      []SourceConfig{{Name: "a"}},
+		SourceMaxAge: "1h",
+		Filters:      map[string]FilterConfig{"humidity": {Max: float64Ptr(100)}},
+	}
+	fieldFilters = make(map[string]map[string]*fieldFilter)
+	accepted := time.Date(2024, 6, 21, 22, 0, 0, 0, time.UTC)
+
+	read := func(date time.Time, humidity float64) sourceReading {
+		reading := sourceReading{
+			Data:     WeatherData{Date: date, Humidity: humidity, SkyTemperature: -20},
+			Fields:   []string{"humidity", "skyTemperature"},
+			Received: date,
+		}
+		filterReading("a", &reading)
+		sourceReadings = map[string]sourceReading{"a": reading}
+		return reading
+	}
+
+	read(accepted, 60)
+	later := accepted.Add(10 * time.Minute)
+	reading := read(later, 140)
+	if got := reading.Held["humidity"]; !got.Equal(accepted) {
+		t.Errorf("held humidity dated %v, want %v", got, accepted)
+	}
+
+	fused := fuseWeatherData(later)
+	if fused.Humidity != 60 || fused.Raw["humidity"] != 140 || !containsString(fused.Rejected, "humidity") {
+		t.Errorf("fused humidity %v raw %v rejected %v", fused.Humidity, fused.Raw, fused.Rejected)
+	}
+	if got := fused.Sensors["humidity"].Updated; !got.Equal(accepted) {
+		t.Errorf("held humidity updated %v, want when it was accepted %v", got, accepted)
+	}
+	if got := fused.Sensors["skyTemperature"].Updated; !got.Equal(later) {
+		t.Errorf("unfiltered field updated %v, want %v", got, later)
+	}
+
+	read(later.Add(time.Minute), 70)
+	if fused := fuseWeatherData(later); !fused.Sensors["humidity"].Updated.Equal(later.Add(time.Minute)) {
+		t.Errorf("accepted humidity updated %v", fused.Sensors["humidity"].Updated)
+	}
+}
+
+func TestValidateFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters map[string]FilterConfig
+		wantErr bool
+	}{
+		{"valid", map[string]FilterConfig{"humidity": {Min: float64Ptr(0), Max: float64Ptr(100), Median: 5}}, false},
+		{"unknown field", map[string]FilterConfig{"humidty": {}}, true},
+		{"text field", map[string]FilterConfig{"cloudCondition": {}}, true},
+		{"derived field", map[string]FilterConfig{"dewPointDepression": {}}, true},
+		{"min above max", map[string]FilterConfig{"humidity": {Min: float64Ptr(100), Max: float64Ptr(0)}}, true},
+		{"negative rate", map[string]FilterConfig{"humidity": {MaxChangePerMinute: float64Ptr(-1)}}, true},
+		{"even median", map[string]FilterConfig{"humidity": {Median: 4}}, true},
+	}
+	for _, tt := range tests {
+		config = Config{Filters: tt.filters}
+		if err := validateFilters(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateFilters() = %v", tt.name, err)
+		}
+	}
+}
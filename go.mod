@@ -2,4 +2,4 @@ module Weatherdata
 
 go 1.22
 
-require github.com/gorilla/mux v1.8.1 // indirect
+require github.com/gorilla/mux v1.8.1
@@ -8,11 +8,16 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"reflect"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+// driverVersion is reported by the Alpaca and status endpoints
+const driverVersion = "0.1"
+
 // Global variable to store the connected state
 var (
 	isConnected bool
@@ -29,75 +34,20 @@ type AlpacaResponse struct {
 }
 
 func handleHome(w http.ResponseWriter, r *http.Request) {
-	tmpl := `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Boltwood II Weather Data</title>
-    <style>
-        body { font-family: Arial, sans-serif; line-height: 1.6; padding: 20px; }
-        h1 { color: #333; }
-        #weather-data { background: #f4f4f4; padding: 20px; border-radius: 5px; }
-        .data-item { margin-bottom: 10px; }
-    </style>
-</head>
-<body>
-    <h1>Current Weather Conditions</h1>
-    <div id="weather-data">Loading...</div>
-
-    <script>
-        const pollingInterval = {{.PollingInterval}};
-        
-        function updateWeatherData() {
-            fetch('/api/weather')
-                .then(response => response.json())
-                .then(data => {
-                    const weatherDiv = document.getElementById('weather-data');
-                    weatherDiv.innerHTML = generateWeatherHTML(data);
-                })
-                .catch(error => console.error('Error fetching weather data:', error));
-        }
-
-        function generateWeatherHTML(data) {
-            return ` + "`" + `
-                <div class="data-item">Date: ${new Date(data.date).toLocaleString()}</div>
-                <div class="data-item">Sky Temperature: ${data.skyTemperature.toFixed(1)}${data.temperatureScale}</div>
-                <div class="data-item">Ambient Temperature: ${data.ambientTemperature.toFixed(1)}${data.temperatureScale}</div>
-                <div class="data-item">Sensor Temperature: ${data.sensorTemperature.toFixed(1)}${data.temperatureScale}</div>
-                <div class="data-item">Wind Speed: ${data.windSpeed.toFixed(1)} ${data.windSpeedScale}</div>
-                <div class="data-item">Humidity: ${data.humidity.toFixed(1)}%</div>
-                <div class="data-item">Dew Point: ${data.dewPoint.toFixed(1)}${data.temperatureScale}</div>
-                <div class="data-item">Dew Heater: ${data.dewHeaterPercentage.toFixed(1)}%</div>
-                <div class="data-item">Rain Flag: ${data.rainFlag}</div>
-                <div class="data-item">Wet Flag: ${data.wetFlag}</div>
-                <div class="data-item">Cloud Condition: ${data.cloudCondition}</div>
-                <div class="data-item">Wind Condition: ${data.windCondition}</div>
-                <div class="data-item">Rain Condition: ${data.rainCondition}</div>
-                <div class="data-item">Darkness Condition: ${data.darknessCondition}</div>
-                <div class="data-item">Alert Status: ${data.alertStatus}</div>
-            ` + "`" + `;
-        }
-
-        updateWeatherData();
-        setInterval(updateWeatherData, pollingInterval);
-    </script>
-</body>
-</html>
-`
-
-	t, err := template.New("home").Parse(tmpl)
+	t, err := template.ParseFS(templateFiles, "templates/index.html")
 	if err != nil {
 		log.Printf("Error parsing template: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
+	maxDataAge, _ := time.ParseDuration(config.Safety.MaxDataAge)
 	data := struct {
 		PollingInterval int
+		MaxDataAge      int
 	}{
 		PollingInterval: int(getPollingIntervalMilliseconds()),
+		MaxDataAge:      int(maxDataAge.Milliseconds()),
 	}
 
 	w.Header().Set("Content-Type", "text/html")
@@ -117,11 +67,91 @@ func getPollingIntervalMilliseconds() int64 {
 	return duration.Milliseconds()
 }
 
+// ASCOM error numbers returned in the ErrorNumber field
+const (
+	errNotImplemented   = 0x400 // Property or method not implemented
+	errInvalidValue     = 0x401 // Invalid value supplied by the client
+	errValueNotSet      = 0x402 // Value has not been set yet
+	errNotConnected     = 0x407 // Device is not connected
+	errInvalidOperation = 0x40B // Operation not allowed in the current state
+)
+
+// alpacaError is an ASCOM error with a specific error number. It is reported
+// with HTTP 200 as the Alpaca specification requires for device errors.
+type alpacaError struct {
+	Number  int
+	Message string
+}
+
+func (e *alpacaError) Error() string {
+	return e.Message
+}
+
+func newAlpacaError(number int, format string, args ...interface{}) error {
+	return &alpacaError{Number: number, Message: fmt.Sprintf(format, args...)}
+}
+
+// getAlpacaParam returns a request parameter, matching its name without regard
+// to case as Alpaca requires
+func getAlpacaParam(r *http.Request, name string) string {
+	r.ParseForm()
+	for key, values := range r.Form {
+		if strings.EqualFold(key, name) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
 // handleAlpacaResponse is a generic function to handle Alpaca API responses
 func handleAlpacaResponse(w http.ResponseWriter, r *http.Request, getValue func() (interface{}, error)) {
+	handleAlpacaRequest(w, r, http.MethodGet, getValue)
+}
+
+// handleAlpacaAction handles Alpaca PUT methods, which return no value
+func handleAlpacaAction(w http.ResponseWriter, r *http.Request, action func() error) {
+	handleAlpacaRequest(w, r, http.MethodPut, func() (interface{}, error) {
+		return nil, action()
+	})
+}
+
+// alpacaProperty returns a handler for a constant device property
+func alpacaProperty(value interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handleAlpacaResponse(w, r, func() (interface{}, error) {
+			return value, nil
+		})
+	}
+}
+
+// alpacaConnected returns a handler for a device's Connected property
+func alpacaConnected(connected *bool, mu *sync.Mutex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			handleAlpacaResponse(w, r, func() (interface{}, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				return *connected, nil
+			})
+			return
+		}
+		handleAlpacaAction(w, r, func() error {
+			value, err := strconv.ParseBool(getAlpacaParam(r, "Connected"))
+			if err != nil {
+				return newAlpacaError(errInvalidValue, "Invalid Connected value")
+			}
+			mu.Lock()
+			*connected = value
+			mu.Unlock()
+			return nil
+		})
+	}
+}
+
+func handleAlpacaRequest(w http.ResponseWriter, r *http.Request, method string, getValue func() (interface{}, error)) {
 	w.Header().Set("Content-Type", "application/json")
 
-	clientTransactionIDStr := r.URL.Query().Get("ClientTransactionID")
+	clientTransactionIDStr := getAlpacaParam(r, "ClientTransactionID")
 	clientTransactionID, err := strconv.ParseUint(clientTransactionIDStr, 10, 32)
 	if err != nil {
 		clientTransactionID = 0
@@ -132,7 +162,7 @@ func handleAlpacaResponse(w http.ResponseWriter, r *http.Request, getValue func(
 		ServerTransactionID: uint32(getNextTransactionID()),
 	}
 
-	if r.Method != http.MethodGet {
+	if r.Method != method {
 		response.ErrorNumber = 1007 // Invalid Operation
 		response.ErrorMessage = "Method not allowed"
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -141,7 +171,10 @@ func handleAlpacaResponse(w http.ResponseWriter, r *http.Request, getValue func(
 	}
 
 	value, err := getValue()
-	if err != nil {
+	if ascomErr, ok := err.(*alpacaError); ok {
+		response.ErrorNumber = ascomErr.Number
+		response.ErrorMessage = ascomErr.Message
+	} else if err != nil {
 		response.ErrorNumber = 1001 // General Error
 		response.ErrorMessage = err.Error()
 		w.WriteHeader(http.StatusInternalServerError)
@@ -160,51 +193,233 @@ func handleDescription(w http.ResponseWriter, r *http.Request) {
 
 func handleDriverInfo(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{
-		"Value": "ASCOM Alpaca Boltwood II Weather Data Driver v0.1",
+		"Value": "ASCOM Alpaca Boltwood II Weather Data Driver v" + driverVersion,
 	})
 }
 
 func handleDriverVersion(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{
-		"Value": "v0.1",
+		"Value": "v" + driverVersion,
 	})
 }
 
 func handleTemperature(w http.ResponseWriter, r *http.Request) {
 	handleAlpacaResponse(w, r, func() (interface{}, error) {
-		return weatherData.SensorTemperature, nil
+		return getSensorValue("sensorTemperature")
 	})
 }
 
 func handleHumidity(w http.ResponseWriter, r *http.Request) {
 	handleAlpacaResponse(w, r, func() (interface{}, error) {
-		return weatherData.Humidity, nil
+		return getSensorValue("humidity")
 	})
 }
 
 func handleDewPoint(w http.ResponseWriter, r *http.Request) {
 	handleAlpacaResponse(w, r, func() (interface{}, error) {
-		return weatherData.DewPoint, nil
+		return getSensorValue("dewPoint")
 	})
 }
 
 func handleWindSpeed(w http.ResponseWriter, r *http.Request) {
 	handleAlpacaResponse(w, r, func() (interface{}, error) {
-		return weatherData.WindSpeed, nil
+		return getSensorValue("windSpeed")
 	})
 }
 
+func handleSkyTemperature(w http.ResponseWriter, r *http.Request) {
+	handleAlpacaResponse(w, r, func() (interface{}, error) {
+		return getSensorValue("skyTemperature")
+	})
+}
+
+func handlePressure(w http.ResponseWriter, r *http.Request) {
+	handleAlpacaResponse(w, r, func() (interface{}, error) {
+		return getSensorValue("pressure")
+	})
+}
+
+func handleSkyQuality(w http.ResponseWriter, r *http.Request) {
+	handleAlpacaResponse(w, r, func() (interface{}, error) {
+		return getSensorValue("skyQuality")
+	})
+}
+
+func handleCloudCover(w http.ResponseWriter, r *http.Request) {
+	handleAlpacaResponse(w, r, func() (interface{}, error) {
+		return getSensorValue("cloudCover")
+	})
+}
+
+// ascomSensorFields maps lower-cased ObservingConditions sensor names to the
+// WeatherData field serving them. Sensors this driver cannot serve map to "".
+var ascomSensorFields = map[string]string{
+	"cloudcover":     "cloudCover",
+	"dewpoint":       "dewPoint",
+	"humidity":       "humidity",
+	"pressure":       "pressure",
+	"rainrate":       "",
+	"skybrightness":  "",
+	"skyquality":     "skyQuality",
+	"skytemperature": "skyTemperature",
+	"starfwhm":       "",
+	"temperature":    "sensorTemperature",
+	"winddirection":  "",
+	"windgust":       "",
+	"windspeed":      "windSpeed",
+}
+
+// Human readable descriptions of the measured WeatherData fields
+var sensorDescriptions = map[string]string{
+	"skyTemperature":    "Infrared sky temperature",
+	"sensorTemperature": "Temperature at the sensor head",
+	"windSpeed":         "Wind speed",
+	"humidity":          "Relative humidity",
+	"dewPoint":          "Dew point",
+	"pressure":          "Barometric pressure",
+	"skyQuality":        "Sky quality (magnitudes per square arcsecond)",
+	"cloudCover":        "Cloud cover estimated from the infrared sky temperature",
+}
+
+// lookupSensor resolves an ASCOM sensor name to the WeatherData field
+// serving it, failing with InvalidValue for names ASCOM does not define and
+// NotImplemented for sensors no configured source supplies
+func lookupSensor(sensorName string) (string, error) {
+	key, ok := ascomSensorFields[strings.ToLower(sensorName)]
+	if !ok {
+		return "", newAlpacaError(errInvalidValue, "unknown sensor name %q", sensorName)
+	}
+	if key == "" || !sensorSupported(key) {
+		return "", newAlpacaError(errNotImplemented, "sensor %s is not implemented", sensorName)
+	}
+	return key, nil
+}
+
+// sensorSupported reports whether a configured source can supply a field,
+// either by its type or because it has done so already. Derived fields, and
+// source fields that can be calculated, are supported when all their inputs are.
+func sensorSupported(key string) bool {
+	if inputs := derivedWeatherFields[key]; len(inputs) > 0 {
+		return inputsSupported(inputs)
+	}
+	for _, src := range config.Sources {
+		if containsString(sourceTypeFields(src.Type), key) {
+			return true
+		}
+	}
+	if _, ok := getWeatherData().Sensors[key]; ok {
+		return true
+	}
+	inputs, ok := calculatedSourceFields[key]
+	return ok && inputsSupported(inputs)
+}
+
+func inputsSupported(inputs []string) bool {
+	for _, input := range inputs {
+		if !sensorSupported(input) {
+			return false
+		}
+	}
+	return true
+}
+
+// getSensorValue returns a field's current value for a property handler
+func getSensorValue(key string) (interface{}, error) {
+	if !sensorSupported(key) {
+		return nil, newAlpacaError(errNotImplemented, "%s is not provided by any source", key)
+	}
+	data := getWeatherData()
+	if _, ok := data.Sensors[key]; !ok {
+		return nil, newAlpacaError(errValueNotSet, "no %s reading has been received yet", key)
+	}
+	return reflect.ValueOf(data).Field(weatherFieldIndex[key]).Interface(), nil
+}
+
+func handleSensorDescription(w http.ResponseWriter, r *http.Request) {
+	handleAlpacaResponse(w, r, func() (interface{}, error) {
+		key, err := lookupSensor(getAlpacaParam(r, "SensorName"))
+		if err != nil {
+			return nil, err
+		}
+		return describeSensor(key, getWeatherData().Sensors[key]), nil
+	})
+}
+
+func handleTimeSinceLastUpdate(w http.ResponseWriter, r *http.Request) {
+	handleAlpacaResponse(w, r, func() (interface{}, error) {
+		data := getWeatherData()
+		sensorName := getAlpacaParam(r, "SensorName")
+
+		// An empty name asks for the most recent update of any sensor
+		if sensorName == "" {
+			if data.Date.IsZero() {
+				return nil, newAlpacaError(errValueNotSet, "no weather data has been received yet")
+			}
+			return time.Since(data.Date).Seconds(), nil
+		}
+
+		key, err := lookupSensor(sensorName)
+		if err != nil {
+			return nil, err
+		}
+		info, ok := data.Sensors[key]
+		if !ok {
+			return nil, newAlpacaError(errValueNotSet, "no %s reading has been received yet", sensorName)
+		}
+		return time.Since(info.Updated).Seconds(), nil
+	})
+}
+
+// describeSensor describes a field and the source currently supplying it
+func describeSensor(key string, info SensorInfo) string {
+	description := sensorDescriptions[key]
+	if info.Source == "" {
+		return description
+	}
+	if inputs, ok := calculatedSourceFields[key]; ok && info.Source == "derived" {
+		return fmt.Sprintf("%s calculated from %s", description, strings.Join(inputs, " and "))
+	}
+	for _, src := range config.Sources {
+		if src.Name == info.Source && src.Description != "" {
+			return fmt.Sprintf("%s from %s (%s)", description, src.Description, src.Name)
+		}
+	}
+	return fmt.Sprintf("%s from %s", description, info.Source)
+}
+
+func handleRefresh(w http.ResponseWriter, r *http.Request) {
+	handleAlpacaAction(w, r, func() error {
+		return refreshWeatherData(r.Context())
+	})
+}
+
+// handleRefreshAPI polls the sources immediately and returns the new data
+func handleRefreshAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := refreshWeatherData(r.Context()); err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(getWeatherData())
+}
+
 func handleWeatherAPI(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(weatherData)
+	json.NewEncoder(w).Encode(getLiveWeatherData())
 }
 
-func handleStatus(w http.ResponseWriter, r *http.Request) {
-	// Implement status endpoint
+// getLiveWeatherData returns the current data with the safety verdict
+// re-evaluated now, so that stale data reads as unsafe
+func getLiveWeatherData() WeatherData {
+	data := getWeatherData()
+	verdict := currentSafety()
+	data.Safe, data.UnsafeReasons = verdict.Safe, verdict.Reasons
+	return data
 }
 
 func handleWeather(w http.ResponseWriter, r *http.Request) {
-	json.NewEncoder(w).Encode(weatherData)
+	json.NewEncoder(w).Encode(getWeatherData())
 }
 
 func handleAPIVersions(w http.ResponseWriter, r *http.Request) {
@@ -223,6 +438,22 @@ func handleConfiguredDevices(w http.ResponseWriter, r *http.Request) {
 			"UniqueID":     generateUniqueID(),
 		},
 	}
+	if dome != nil {
+		devices = append(devices, map[string]interface{}{
+			"DeviceName":   config.Dome.Name,
+			"DeviceType":   "Dome",
+			"DeviceNumber": 0,
+			"UniqueID":     generateUniqueID(),
+		})
+	}
+	if switches != nil {
+		devices = append(devices, map[string]interface{}{
+			"DeviceName":   config.Switch.Name,
+			"DeviceType":   "Switch",
+			"DeviceNumber": 0,
+			"UniqueID":     generateUniqueID(),
+		})
+	}
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"Value": devices,
 	})
@@ -243,7 +474,7 @@ func handleManagementDescription(w http.ResponseWriter, r *http.Request) {
 	}{
 		ServerName:          "Boltwood II ASCOM Alpaca Server",
 		Manufacturer:        "GregOberfield",
-		ManufacturerVersion: "0.1",
+		ManufacturerVersion: driverVersion,
 		Location:            hostname,
 		//ServerVersion:         "1.0",
 		//ServerAPIVersion:      "1",
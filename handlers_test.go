@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// callAlpaca serves one GET request and decodes the Alpaca response
+func callAlpaca(t *testing.T, handler http.HandlerFunc, query string) AlpacaResponse {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/api/v1/observingconditions/0/x?"+query, nil))
+	var response AlpacaResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+	return response
+}
+
+func setTestWeatherData(t *testing.T, sources []SourceConfig, data WeatherData) {
+	t.Helper()
+	config = Config{Sources: sources}
+	weatherMutex.Lock()
+	weatherData = data
+	weatherMutex.Unlock()
+	t.Cleanup(func() {
+		weatherMutex.Lock()
+		weatherData = WeatherData{}
+		weatherMutex.Unlock()
+	})
+}
+
+func TestSensorGetters(t *testing.T) {
+	updated := time.Now().Add(-30 * time.Second)
+	setTestWeatherData(t, []SourceConfig{{Name: "roof", Type: "boltwood"}}, WeatherData{
+		Date:              updated,
+		SkyTemperature:    -21.5,
+		SensorTemperature: 12.5,
+		Sensors: map[string]SensorInfo{
+			"skyTemperature":    {Source: "roof", Updated: updated},
+			"sensorTemperature": {Source: "roof", Updated: updated},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    float64
+		wantErr int
+	}{
+		{"skytemperature", handleSkyTemperature, -21.5, 0},
+		{"temperature", handleTemperature, 12.5, 0},
+		{"humidity not received yet", handleHumidity, 0, errValueNotSet},
+		{"dewpoint not received yet", handleDewPoint, 0, errValueNotSet},
+		{"windspeed not received yet", handleWindSpeed, 0, errValueNotSet},
+		{"pressure from no source", handlePressure, 0, errNotImplemented},
+		{"skyquality from no source", handleSkyQuality, 0, errNotImplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := callAlpaca(t, tt.handler, "ClientTransactionID=7")
+			if response.ErrorNumber != tt.wantErr {
+				t.Fatalf("ErrorNumber = 0x%X (%s), want 0x%X", response.ErrorNumber, response.ErrorMessage, tt.wantErr)
+			}
+			if response.ClientTransactionID != 7 {
+				t.Errorf("ClientTransactionID = %d, want 7", response.ClientTransactionID)
+			}
+			if tt.wantErr == 0 && response.Value != tt.want {
+				t.Errorf("Value = %v, want %v", response.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestSensorDescriptionAndAge(t *testing.T) {
+	updated := time.Now().Add(-30 * time.Second)
+	setTestWeatherData(t, []SourceConfig{{Name: "roof", Type: "boltwood", Description: "Roof Boltwood"}}, WeatherData{
+		Date:           updated,
+		SkyTemperature: -21.5,
+		Sensors:        map[string]SensorInfo{"skyTemperature": {Source: "roof", Updated: updated.Add(-time.Minute)}},
+	})
+
+	descriptions := []struct {
+		sensor  string
+		want    string
+		wantErr int
+	}{
+		{"SkyTemperature", "Infrared sky temperature from Roof Boltwood (roof)", 0},
+		{"humidity", "Relative humidity", 0},
+		{"RainRate", "", errNotImplemented},
+		{"Pressure", "", errNotImplemented},
+		{"Visibility", "", errInvalidValue},
+	}
+	for _, tt := range descriptions {
+		response := callAlpaca(t, handleSensorDescription, "SensorName="+tt.sensor)
+		if response.ErrorNumber != tt.wantErr || (tt.wantErr == 0 && response.Value != tt.want) {
+			t.Errorf("sensordescription %s = %v, 0x%X; want %q, 0x%X", tt.sensor, response.Value, response.ErrorNumber, tt.want, tt.wantErr)
+		}
+	}
+
+	ages := []struct {
+		sensor  string
+		want    float64
+		wantErr int
+	}{
+		{"", 30, 0},
+		{"skytemperature", 90, 0},
+		{"Humidity", 0, errValueNotSet},
+		{"WindGust", 0, errNotImplemented},
+	}
+	for _, tt := range ages {
+		response := callAlpaca(t, handleTimeSinceLastUpdate, "SensorName="+tt.sensor)
+		if response.ErrorNumber != tt.wantErr {
+			t.Errorf("timesincelastupdate %q: ErrorNumber 0x%X, want 0x%X", tt.sensor, response.ErrorNumber, tt.wantErr)
+			continue
+		}
+		if age, _ := response.Value.(float64); tt.wantErr == 0 && math.Abs(age-tt.want) > 2 {
+			t.Errorf("timesincelastupdate %q = %v, want about %v", tt.sensor, response.Value, tt.want)
+		}
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	config = Config{PollingInterval: "30s", Safety: SafetyConfig{MaxDataAge: "2m30s"}}
+	w := httptest.NewRecorder()
+	handleHome(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := w.Body.String()
+	intervals := regexp.MustCompile(`pollingInterval:\s*30000\s*,[\s\S]*maxDataAge:\s*150000\s*,`)
+	if w.Code != http.StatusOK || !intervals.MatchString(body) {
+		t.Errorf("home page %d without the intervals in milliseconds:\n%s", w.Code, body)
+	}
+
+	// The page's scripts and styles are served from the binary
+	for _, path := range []string{"/static/dashboard.js", "/static/dashboard.css", "/static/charts.js"} {
+		w := httptest.NewRecorder()
+		staticHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK || w.Body.Len() == 0 {
+			t.Errorf("%s: status %d, %d bytes", path, w.Code, w.Body.Len())
+		}
+		if !strings.Contains(body, `"`+path+`"`) {
+			t.Errorf("home page does not load %s", path)
+		}
+	}
+}
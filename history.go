@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// weatherHistory is a fixed-size ring buffer of recent WeatherData samples
+type weatherHistory struct {
+	mu      sync.RWMutex
+	samples []WeatherData
+	start   int // Index of the oldest sample
+	count   int
+	maxAge  time.Duration
+}
+
+// historyPoint summarises the samples of one field falling in a time bucket
+type historyPoint struct {
+	Time  time.Time `json:"time"`
+	Min   float64   `json:"min"`
+	Mean  float64   `json:"mean"`
+	Max   float64   `json:"max"`
+	Count int       `json:"count"`
+}
+
+var history *weatherHistory
+
+// initHistory sizes the history buffer to hold HistoryDuration worth of
+// samples, allowing one sample per source per polling interval
+func initHistory() {
+	maxAge, _ := time.ParseDuration(config.HistoryDuration)
+	interval, _ := time.ParseDuration(config.PollingInterval)
+	capacity := int(maxAge/interval+1) * len(config.Sources)
+
+	history = newWeatherHistory(capacity, maxAge)
+	onWeatherUpdate(history.add)
+}
+
+func newWeatherHistory(capacity int, maxAge time.Duration) *weatherHistory {
+	return &weatherHistory{
+		samples: make([]WeatherData, capacity),
+		maxAge:  maxAge,
+	}
+}
+
+// add appends a sample, overwriting the oldest once the buffer is full.
+// Repeats of the newest sample, as from an unchanged data file, are skipped.
+func (h *weatherHistory) add(data WeatherData) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.count > 0 && isRepeatSample(h.samples[(h.start+h.count-1)%len(h.samples)], data) {
+		return
+	}
+
+	if h.count < len(h.samples) {
+		h.samples[(h.start+h.count)%len(h.samples)] = data
+		h.count++
+	} else {
+		h.samples[h.start] = data
+		h.start = (h.start + 1) % len(h.samples)
+	}
+}
+
+// isRepeatSample reports whether data holds no reading newer than last: the
+// same date and the same source and update time for every source field.
+// Derived fields are ignored, as they are stamped afresh on every publish,
+// except that a change of safety verdict or trend is always recorded.
+func isRepeatSample(last, data WeatherData) bool {
+	if !last.Date.Equal(data.Date) || last.Safe != data.Safe || last.Trend != data.Trend {
+		return false
+	}
+	sourceFields := 0
+	for key, info := range data.Sensors {
+		if _, derived := derivedWeatherFields[key]; derived {
+			continue
+		}
+		previous, ok := last.Sensors[key]
+		if !ok || previous.Source != info.Source || !previous.Updated.Equal(info.Updated) {
+			return false
+		}
+		sourceFields++
+	}
+	for key := range last.Sensors {
+		if _, derived := derivedWeatherFields[key]; !derived {
+			sourceFields--
+		}
+	}
+	return sourceFields == 0
+}
+
+// size returns the number of samples held
+func (h *weatherHistory) size() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.count
+}
+
+// query returns the samples dated within [from, to] in chronological order
+func (h *weatherHistory) query(from, to time.Time) []WeatherData {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	oldest := time.Now().Add(-h.maxAge)
+	var result []WeatherData
+	for i := 0; i < h.count; i++ {
+		sample := h.samples[(h.start+i)%len(h.samples)]
+		if sample.Date.Before(from) || sample.Date.After(to) || sample.Date.Before(oldest) {
+			continue
+		}
+		result = append(result, sample)
+	}
+	return result
+}
+
+// downsampleHistory summarises a numeric field per step-sized bucket starting
+// at from. A zero step returns every sample as its own point. With raw set,
+// the values read before the outlier filters are used.
+func downsampleHistory(samples []WeatherData, key string, from time.Time, step time.Duration, raw bool) []historyPoint {
+	points := []historyPoint{}
+	for i := range samples {
+		value, ok := weatherFieldValue(&samples[i], key)
+		if !ok {
+			continue
+		}
+		if rawValue, filtered := samples[i].Raw[key]; raw && filtered {
+			value = rawValue
+		}
+		if _, provided := samples[i].Sensors[key]; !provided {
+			continue
+		}
+
+		bucket := samples[i].Date
+		if step > 0 {
+			bucket = from.Add(samples[i].Date.Sub(from) / step * step)
+		}
+
+		last := len(points) - 1
+		if last >= 0 && points[last].Time.Equal(bucket) {
+			p := &points[last]
+			p.Min = math.Min(p.Min, value)
+			p.Max = math.Max(p.Max, value)
+			p.Mean += (value - p.Mean) / float64(p.Count+1)
+			p.Count++
+			continue
+		}
+		points = append(points, historyPoint{Time: bucket, Min: value, Mean: value, Max: value, Count: 1})
+	}
+	return points
+}
+
+// hasSensors reports whether a sample has readings for all the keys
+func hasSensors(sample WeatherData, keys ...string) bool {
+	for _, key := range keys {
+		if _, ok := sample.Sensors[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// medianValue is the median of values, averaging the middle two of an even count
+func medianValue(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// parseHistoryTime accepts an RFC3339 time, Unix seconds, or a duration
+// meaning that long before now (e.g. "2h")
+func parseHistoryTime(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	if d, err := time.ParseDuration(strings.TrimPrefix(value, "-")); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+// parseHistoryRange reads the from and to query parameters, defaulting to
+// the whole history buffer
+func parseHistoryRange(r *http.Request) (time.Time, time.Time, error) {
+	now := time.Now()
+	maxAge, _ := time.ParseDuration(config.HistoryDuration)
+	from, to := now.Add(-maxAge), now
+
+	var err error
+	if value := r.URL.Query().Get("from"); value != "" {
+		if from, err = parseHistoryTime(value, now); err != nil {
+			return from, to, err
+		}
+	}
+	if value := r.URL.Query().Get("to"); value != "" {
+		if to, err = parseHistoryTime(value, now); err != nil {
+			return from, to, err
+		}
+	}
+	if to.Before(from) {
+		return from, to, fmt.Errorf("to is before from")
+	}
+	return from, to, nil
+}
+
+func handleHistoryAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	from, to, err := parseHistoryRange(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	var step time.Duration
+	if value := r.URL.Query().Get("step"); value != "" {
+		if step, err = time.ParseDuration(value); err != nil || step < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("invalid step %q", value)})
+			return
+		}
+	}
+
+	fields := numericWeatherFields()
+	if value := r.URL.Query().Get("fields"); value != "" {
+		fields = strings.Split(value, ",")
+		var data WeatherData
+		for _, key := range fields {
+			if _, ok := weatherFieldValue(&data, key); !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("unknown numeric field %q", key)})
+				return
+			}
+		}
+	}
+
+	raw := r.URL.Query().Get("raw") == "true"
+	samples := queryHistory(from, to)
+	series := make(map[string][]historyPoint)
+	for _, key := range fields {
+		series[key] = downsampleHistory(samples, key, from, step, raw)
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		From   time.Time                 `json:"from"`
+		To     time.Time                 `json:"to"`
+		Step   string                    `json:"step"`
+		Series map[string][]historyPoint `json:"series"`
+	}{from, to, step.String(), series})
+}
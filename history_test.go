@@ -0,0 +1,155 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func historySample(date time.Time, humidity float64) WeatherData {
+	return WeatherData{
+		Date:     date,
+		Humidity: humidity,
+		Sensors:  map[string]SensorInfo{"humidity": {Source: "test", Updated: date}},
+	}
+}
+
+func TestWeatherHistoryRing(t *testing.T) {
+	now := time.Now()
+	h := newWeatherHistory(3, time.Hour)
+	for i := 0; i < 5; i++ {
+		h.add(historySample(now.Add(time.Duration(i-5)*time.Minute), float64(i)))
+	}
+
+	if h.size() != 3 {
+		t.Fatalf("size = %d, want 3", h.size())
+	}
+	samples := h.query(now.Add(-time.Hour), now)
+	if len(samples) != 3 {
+		t.Fatalf("query returned %d samples, want 3", len(samples))
+	}
+	for i, sample := range samples {
+		if want := float64(i + 2); sample.Humidity != want {
+			t.Errorf("sample %d humidity = %v, want %v (oldest overwritten first)", i, sample.Humidity, want)
+		}
+	}
+
+	if got := h.query(now.Add(-150*time.Second), now); len(got) != 2 {
+		t.Errorf("query of the last 2.5 minutes returned %d samples, want 2", len(got))
+	}
+}
+
+func TestDownsampleHistory(t *testing.T) {
+	from := time.Date(2024, 6, 21, 22, 0, 0, 0, time.UTC)
+	samples := []WeatherData{
+		historySample(from, 40),
+		historySample(from.Add(time.Minute), 50),
+		historySample(from.Add(4*time.Minute), 60),
+		historySample(from.Add(5*time.Minute), 70),
+		{Date: from.Add(6 * time.Minute)}, // no humidity reading
+	}
+	samples[3].Raw = map[string]float64{"humidity": 99}
+
+	tests := []struct {
+		name string
+		step time.Duration
+		raw  bool
+		want []historyPoint
+	}{
+		{
+			name: "every sample",
+			want: []historyPoint{
+				{from, 40, 40, 40, 1},
+				{from.Add(time.Minute), 50, 50, 50, 1},
+				{from.Add(4 * time.Minute), 60, 60, 60, 1},
+				{from.Add(5 * time.Minute), 70, 70, 70, 1},
+			},
+		},
+		{
+			name: "five minute buckets",
+			step: 5 * time.Minute,
+			want: []historyPoint{
+				{from, 40, 50, 60, 3},
+				{from.Add(5 * time.Minute), 70, 70, 70, 1},
+			},
+		},
+		{
+			name: "raw values",
+			step: 5 * time.Minute,
+			raw:  true,
+			want: []historyPoint{
+				{from, 40, 50, 60, 3},
+				{from.Add(5 * time.Minute), 99, 99, 99, 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := downsampleHistory(samples, "humidity", from, tt.step, tt.raw)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d points, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i := range got {
+				if !got[i].Time.Equal(tt.want[i].Time) || got[i].Min != tt.want[i].Min ||
+					got[i].Mean != tt.want[i].Mean || got[i].Max != tt.want[i].Max || got[i].Count != tt.want[i].Count {
+					t.Errorf("point %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseHistoryTime(t *testing.T) {
+	now := time.Date(2024, 6, 21, 22, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"2024-06-21T20:00:00Z", now.Add(-2 * time.Hour), false},
+		{"1718999999", time.Unix(1718999999, 0), false},
+		{"2h", now.Add(-2 * time.Hour), false},
+		{"-90m", now.Add(-90 * time.Minute), false},
+		{"yesterday", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseHistoryTime(tt.value, now)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("parseHistoryTime(%q) = %v, %v; want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestHistorySkipsRepeatedReadings(t *testing.T) {
+	now := time.Now()
+	read := now.Add(-time.Minute)
+	publish := func(published time.Time, updated time.Time) WeatherData {
+		sample := historySample(read, 50)
+		sample.Sensors["humidity"] = SensorInfo{Source: "boltwood", Updated: updated}
+		sample.Sensors["safe"] = SensorInfo{Source: "derived", Updated: published}
+		return sample
+	}
+
+	h := newWeatherHistory(10, time.Hour)
+	h.add(publish(now.Add(-50*time.Second), read))
+	h.add(publish(now.Add(-40*time.Second), read)) // unchanged file re-read
+	if h.size() != 1 {
+		t.Errorf("size = %d after re-reading unchanged data, want 1", h.size())
+	}
+
+	h.add(publish(now.Add(-30*time.Second), read.Add(time.Second)))
+	if h.size() != 2 {
+		t.Errorf("size = %d after a field updated, want 2", h.size())
+	}
+
+	// A new verdict or trend is recorded without a new reading
+	verdict := publish(now.Add(-20*time.Second), read.Add(time.Second))
+	verdict.Safe = true
+	h.add(verdict)
+	trend := verdict
+	trend.Trend = "Clearing"
+	h.add(trend)
+	if h.size() != 4 {
+		t.Errorf("size = %d after the verdict and trend changed, want 4", h.size())
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Upper bounds of the poll duration histogram buckets, in seconds
+var pollDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+type alpacaRequestKey struct {
+	Endpoint    string
+	Method      string
+	ErrorNumber int
+}
+
+var (
+	pollDurations  = make(map[string]*histogram)
+	alpacaRequests = make(map[alpacaRequestKey]uint64)
+	metricsMutex   sync.Mutex
+)
+
+// Units appended to the gauge names of numeric WeatherData fields
+var metricUnits = map[string]string{
+	"skyTemperature":     "celsius",
+	"ambientTemperature": "celsius",
+	"sensorTemperature":  "celsius",
+	"dewPoint":           "celsius",
+	"windSpeed":          "meters_per_second",
+	"humidity":           "percent",
+	"pressure":           "hpa",
+	"skyQuality":         "mpsas",
+	"cloudCover":         "percent",
+	"calculatedDewPoint": "celsius",
+	"dewPointDepression": "celsius",
+	"absoluteHumidity":   "grams_per_cubic_meter",
+	"windChill":          "celsius",
+	"sunAltitude":        "degrees",
+	"moonAltitude":       "degrees",
+	"moonIllumination":   "percent",
+
+	"skyAmbientDeltaTrend":    "celsius_per_hour",
+	"humidityTrend":           "percent_per_hour",
+	"dewPointDepressionTrend": "celsius_per_hour",
+	"timeToDew":               "minutes",
+}
+
+// Condition fields and the parser giving their possible values
+var conditionParsers = map[string]func(int) string{
+	"cloudCondition":    parseCloudCondition,
+	"windCondition":     parseWindCondition,
+	"rainCondition":     parseRainCondition,
+	"darknessCondition": parseDarknessCondition,
+	"alertStatus":       parseAlertStatus,
+	"dewRisk":           parseDewRisk,
+	"daylightCondition": parseDaylightCondition,
+	"trend":             parseTrendCondition,
+}
+
+func observePollDuration(source string, duration time.Duration) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	h, ok := pollDurations[source]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(pollDurationBuckets)+1)}
+		pollDurations[source] = h
+	}
+	seconds := duration.Seconds()
+	i := sort.SearchFloat64s(pollDurationBuckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+}
+
+func recordAlpacaRequest(endpoint, method string, errorNumber int) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	alpacaRequests[alpacaRequestKey{endpoint, method, errorNumber}]++
+}
+
+// metricsRecorder captures the status and, for Alpaca device requests, the
+// start of the body so loggingMiddleware can count ASCOM error numbers
+type metricsRecorder struct {
+	http.ResponseWriter
+	status  int
+	capture bool
+	body    []byte
+	route   string // path template of the matched route
+}
+
+const maxCapturedBody = 4096
+
+func (m *metricsRecorder) WriteHeader(status int) {
+	m.status = status
+	m.ResponseWriter.WriteHeader(status)
+}
+
+func (m *metricsRecorder) Write(data []byte) (int, error) {
+	if m.status == 0 {
+		m.status = http.StatusOK
+	}
+	if m.capture && len(m.body) < maxCapturedBody {
+		m.body = append(m.body, data[:min(len(data), maxCapturedBody-len(m.body))]...)
+	}
+	return m.ResponseWriter.Write(data)
+}
+
+// Flush lets streaming handlers work through the recorder
+func (m *metricsRecorder) Flush() {
+	if flusher, ok := m.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (m *metricsRecorder) Unwrap() http.ResponseWriter {
+	return m.ResponseWriter
+}
+
+// recordRoute notes the matched route on the metrics recorder, so that
+// request labels come from the registered routes rather than client paths
+func recordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m, ok := w.(*metricsRecorder); ok {
+			if route := mux.CurrentRoute(r); route != nil {
+				m.route, _ = route.GetPathTemplate()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recordAlpacaMetrics counts a served Alpaca device request by endpoint and
+// the ErrorNumber of its response. Requests matching no route count as other.
+func (m *metricsRecorder) recordAlpacaMetrics(r *http.Request) {
+	if !m.capture {
+		return
+	}
+	var response struct {
+		ErrorNumber int `json:"ErrorNumber"`
+	}
+	json.Unmarshal(m.body, &response)
+	endpoint, method := "other", "other"
+	if m.route != "" {
+		endpoint, method = strings.ToLower(strings.TrimPrefix(m.route, "/api/v1/")), r.Method
+	}
+	recordAlpacaRequest(endpoint, method, response.ErrorNumber)
+}
+
+// metricName converts a camelCase field key to a Prometheus metric name
+func metricName(key string) string {
+	var b strings.Builder
+	b.WriteString("boltwood_")
+	for _, c := range key {
+		if unicode.IsUpper(c) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(c))
+	}
+	if unit, ok := metricUnits[key]; ok {
+		b.WriteString("_" + unit)
+	}
+	return b.String()
+}
+
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeMetricHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// handleMetrics serves the Prometheus text exposition format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	now := time.Now()
+	data := getWeatherData()
+
+	// Numeric fields, only once a source has provided them. The safety
+	// verdict is reported below, evaluated now.
+	for _, key := range numericWeatherFields() {
+		if _, ok := data.Sensors[key]; !ok || key == "safe" {
+			continue
+		}
+		value, _ := weatherFieldValue(&data, key)
+		name := metricName(key)
+		writeMetricHeader(w, name, "gauge", "Current "+key+" reading")
+		fmt.Fprintf(w, "%s %s\n", name, formatMetricValue(value))
+	}
+
+	// Conditions as one labelled gauge per possible value
+	writeMetricHeader(w, "boltwood_condition", "gauge", "Current condition values, 1 for the active value")
+	for _, key := range sortedParserKeys() {
+		if _, ok := data.Sensors[key]; !ok {
+			continue
+		}
+		current, _ := weatherFieldString(&data, key)
+		seen := make(map[string]bool)
+		for code := 0; code <= 3; code++ {
+			value := conditionParsers[key](code)
+			if seen[value] {
+				continue
+			}
+			seen[value] = true
+			active := 0
+			if value == current {
+				active = 1
+			}
+			fmt.Fprintf(w, "boltwood_condition{field=%q,value=\"%s\"} %d\n", key, escapeLabel(value), active)
+		}
+	}
+
+	verdict := currentSafety()
+	safe := 0
+	if verdict.Safe {
+		safe = 1
+	}
+	writeMetricHeader(w, "boltwood_safe", "gauge", "Safety verdict, 1 when safe to observe")
+	fmt.Fprintf(w, "boltwood_safe %d\n", safe)
+	writeMetricHeader(w, "boltwood_unsafe_reasons", "gauge", "Number of reasons conditions are unsafe")
+	fmt.Fprintf(w, "boltwood_unsafe_reasons %d\n", len(verdict.Reasons))
+
+	if !data.Date.IsZero() {
+		writeMetricHeader(w, "boltwood_data_age_seconds", "gauge", "Seconds since the newest weather data")
+		fmt.Fprintf(w, "boltwood_data_age_seconds %s\n", formatMetricValue(now.Sub(data.Date).Seconds()))
+	}
+	writeMetricHeader(w, "boltwood_sensor_age_seconds", "gauge", "Seconds since each field was last updated")
+	for _, key := range weatherFieldKeys {
+		if info, ok := data.Sensors[key]; ok {
+			fmt.Fprintf(w, "boltwood_sensor_age_seconds{field=%q,source=\"%s\"} %s\n",
+				key, escapeLabel(info.Source), formatMetricValue(now.Sub(info.Updated).Seconds()))
+		}
+	}
+
+	// Source polling
+	health := getSourceHealth()
+	names := make([]string, 0, len(health))
+	for name := range health {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writeMetricHeader(w, "boltwood_source_polls_total", "counter", "Source polls")
+	for _, name := range names {
+		fmt.Fprintf(w, "boltwood_source_polls_total{source=\"%s\"} %d\n", escapeLabel(name), health[name].Polls)
+	}
+	writeMetricHeader(w, "boltwood_source_poll_failures_total", "counter", "Source polls that failed")
+	for _, name := range names {
+		fmt.Fprintf(w, "boltwood_source_poll_failures_total{source=\"%s\"} %d\n", escapeLabel(name), health[name].Failures)
+	}
+	writeMetricHeader(w, "boltwood_source_consecutive_failures", "gauge", "Failures since the last successful poll")
+	for _, name := range names {
+		fmt.Fprintf(w, "boltwood_source_consecutive_failures{source=\"%s\"} %d\n", escapeLabel(name), health[name].ConsecutiveFailures)
+	}
+
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	writeMetricHeader(w, "boltwood_source_poll_duration_seconds", "histogram", "Time taken to read and parse a source")
+	for _, name := range names {
+		h, ok := pollDurations[name]
+		if !ok {
+			continue
+		}
+		label := escapeLabel(name)
+		var cumulative uint64
+		for i, bound := range pollDurationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "boltwood_source_poll_duration_seconds_bucket{source=\"%s\",le=\"%s\"} %d\n", label, formatMetricValue(bound), cumulative)
+		}
+		fmt.Fprintf(w, "boltwood_source_poll_duration_seconds_bucket{source=\"%s\",le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(w, "boltwood_source_poll_duration_seconds_sum{source=\"%s\"} %s\n", label, formatMetricValue(h.sum))
+		fmt.Fprintf(w, "boltwood_source_poll_duration_seconds_count{source=\"%s\"} %d\n", label, h.count)
+	}
+
+	writeMetricHeader(w, "boltwood_alpaca_requests_total", "counter", "Alpaca device API requests by endpoint and ASCOM error number")
+	keys := make([]alpacaRequestKey, 0, len(alpacaRequests))
+	for key := range alpacaRequests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Endpoint != keys[j].Endpoint {
+			return keys[i].Endpoint < keys[j].Endpoint
+		}
+		if keys[i].Method != keys[j].Method {
+			return keys[i].Method < keys[j].Method
+		}
+		return keys[i].ErrorNumber < keys[j].ErrorNumber
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "boltwood_alpaca_requests_total{endpoint=\"%s\",method=\"%s\",error_number=\"%d\"} %d\n",
+			escapeLabel(key.Endpoint), key.Method, key.ErrorNumber, alpacaRequests[key])
+	}
+
+	writeMetricHeader(w, "boltwood_stream_clients", "gauge", "Connected /api/stream clients")
+	fmt.Fprintf(w, "boltwood_stream_clients %d\n", events.subscriberCount())
+	writeMetricHeader(w, "boltwood_uptime_seconds", "gauge", "Seconds since the driver started")
+	fmt.Fprintf(w, "boltwood_uptime_seconds %s\n", formatMetricValue(now.Sub(startTime).Seconds()))
+}
+
+func sortedParserKeys() []string {
+	keys := make([]string, 0, len(conditionParsers))
+	for key := range conditionParsers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMetricName(t *testing.T) {
+	tests := map[string]string{
+		"skyTemperature":       "boltwood_sky_temperature_celsius",
+		"rainFlag":             "boltwood_rain_flag",
+		"windSpeed":            "boltwood_wind_speed_meters_per_second",
+		"skyAmbientDeltaTrend": "boltwood_sky_ambient_delta_trend_celsius_per_hour",
+	}
+	for key, want := range tests {
+		if got := metricName(key); got != want {
+			t.Errorf("metricName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	updated := time.Now()
+	setTestWeatherData(t, []SourceConfig{{Name: "roof", Type: "boltwood"}}, WeatherData{
+		Date:           updated,
+		SkyTemperature: -21.5,
+		CloudCondition: "Clear",
+		Sensors: map[string]SensorInfo{
+			"skyTemperature": {Source: "roof", Updated: updated},
+			"cloudCondition": {Source: "roof", Updated: updated},
+		},
+	})
+	history = newWeatherHistory(10, time.Hour)
+	metricsMutex.Lock()
+	alpacaRequests = make(map[alpacaRequestKey]uint64)
+	metricsMutex.Unlock()
+
+	handler := setupRoutes(mux.NewRouter())
+	serve := func(method, path string) string {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w.Body.String()
+	}
+	serve(http.MethodGet, "/api/v1/observingconditions/0/skytemperature")
+	serve(http.MethodGet, "/api/v1/observingconditions/0/skytemperature?ClientID=1")
+	serve(http.MethodGet, "/api/v1/observingconditions/0/pressure")
+	serve(http.MethodGet, "/api/v1/observingconditions/0/x8f3a")
+	serve(http.MethodGet, "/api/v1/observingconditions/0/x9b2c")
+	serve(http.MethodPost, "/api/v1/observingconditions/0/skytemperature")
+
+	body := serve(http.MethodGet, "/metrics")
+	for _, want := range []string{
+		"boltwood_sky_temperature_celsius -21.5\n",
+		`boltwood_condition{field="cloudCondition",value="Clear"} 1` + "\n",
+		`boltwood_condition{field="cloudCondition",value="Very Cloudy"} 0` + "\n",
+		// Requests are labelled by route, and paths matching none are folded together
+		`boltwood_alpaca_requests_total{endpoint="observingconditions/0/skytemperature",method="GET",error_number="0"} 2` + "\n",
+		`boltwood_alpaca_requests_total{endpoint="observingconditions/0/pressure",method="GET",error_number="1024"} 1` + "\n",
+		`boltwood_alpaca_requests_total{endpoint="other",method="other",error_number="0"} 3` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics lack %q", want)
+		}
+	}
+	if strings.Contains(body, "x8f3a") || strings.Contains(body, "boltwood_humidity") {
+		t.Errorf("metrics include unmatched paths or unreported fields:\n%s", body)
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MQTTConfig configures publishing to an MQTT broker with Home Assistant
+// discovery. Publishing is disabled when Broker is empty.
+type MQTTConfig struct {
+	Broker           string `json:"broker"` // tcp://host:1883 or ssl://host:8883
+	ClientID         string `json:"clientId"`
+	Username         string `json:"username"`
+	Password         string `json:"password"`
+	TopicPrefix      string `json:"topicPrefix"`
+	DiscoveryPrefix  string `json:"discoveryPrefix"`
+	DisableDiscovery bool   `json:"disableDiscovery"`
+	DeviceName       string `json:"deviceName"`
+}
+
+// mqttMessage is a PUBLISH to send
+type mqttMessage struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// Home Assistant sensor definitions for the published fields
+type haSensor struct {
+	Component   string // sensor or binary_sensor
+	Key         string
+	Name        string
+	DeviceClass string
+	Unit        string
+	Template    string
+}
+
+var haSensors = []haSensor{
+	{"sensor", "skyTemperature", "Sky temperature", "temperature", "°C", ""},
+	{"sensor", "ambientTemperature", "Ambient temperature", "temperature", "°C", ""},
+	{"sensor", "sensorTemperature", "Sensor temperature", "temperature", "°C", ""},
+	{"sensor", "dewPoint", "Dew point", "temperature", "°C", ""},
+	{"sensor", "humidity", "Humidity", "humidity", "%", ""},
+	{"sensor", "windSpeed", "Wind speed", "wind_speed", "m/s", ""},
+	{"sensor", "pressure", "Pressure", "atmospheric_pressure", "hPa", ""},
+	{"sensor", "skyQuality", "Sky quality", "", "mag/arcsec²", ""},
+	{"sensor", "cloudCover", "Cloud cover", "", "%", ""},
+	{"sensor", "calculatedDewPoint", "Calculated dew point", "temperature", "°C", ""},
+	{"sensor", "dewPointDepression", "Dew point depression", "", "°C", ""},
+	{"sensor", "absoluteHumidity", "Absolute humidity", "absolute_humidity", "g/m³", ""},
+	{"sensor", "windChill", "Wind chill", "temperature", "°C", ""},
+	{"sensor", "dewRisk", "Dew risk", "", "", ""},
+	{"sensor", "sunAltitude", "Sun altitude", "", "°", ""},
+	{"sensor", "moonAltitude", "Moon altitude", "", "°", ""},
+	{"sensor", "moonIllumination", "Moon illumination", "", "%", ""},
+	{"sensor", "daylightCondition", "Daylight", "", "", ""},
+	{"sensor", "trend", "Trend", "", "", ""},
+	{"sensor", "skyAmbientDeltaTrend", "Sky minus ambient trend", "", "°C/h", ""},
+	{"sensor", "humidityTrend", "Humidity trend", "", "%/h", ""},
+	{"sensor", "timeToDew", "Time to dew", "duration", "min", ""},
+	{"sensor", "dewHeaterPercentage", "Dew heater", "", "%", ""},
+	{"sensor", "cloudCondition", "Clouds", "", "", ""},
+	{"sensor", "windCondition", "Wind", "", "", ""},
+	{"sensor", "rainCondition", "Rain", "", "", ""},
+	{"sensor", "darknessCondition", "Darkness", "", "", ""},
+	{"sensor", "alertStatus", "Alert", "", "", ""},
+	{"binary_sensor", "rainFlag", "Rain detected", "moisture", "", "{{ 'ON' if value_json.rainFlag else 'OFF' }}"},
+	{"binary_sensor", "wetFlag", "Wet", "moisture", "", "{{ 'ON' if value_json.wetFlag else 'OFF' }}"},
+	{"binary_sensor", "roofCloseFlag", "Roof close requested", "", "", "{{ 'ON' if value_json.roofCloseFlag else 'OFF' }}"},
+	{"binary_sensor", "dewPointMismatch", "Dew point mismatch", "problem", "", "{{ 'ON' if value_json.dewPointMismatch else 'OFF' }}"},
+}
+
+const mqttKeepAlive = 60 * time.Second
+
+var mqttMessages chan mqttMessage
+
+func validateMQTTConfig() error {
+	m := &config.MQTT
+	if m.Broker == "" {
+		return nil
+	}
+	u, err := url.Parse(m.Broker)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid MQTT.Broker in config file: %q", m.Broker)
+	}
+	switch u.Scheme {
+	case "tcp", "mqtt", "ssl", "tls", "mqtts":
+	default:
+		return fmt.Errorf("unsupported MQTT.Broker scheme %q", u.Scheme)
+	}
+
+	if m.ClientID == "" {
+		m.ClientID = "boltwood-alpaca"
+	}
+	if m.TopicPrefix == "" {
+		m.TopicPrefix = "observatory/weather"
+	}
+	m.TopicPrefix = strings.TrimRight(m.TopicPrefix, "/")
+	if m.DiscoveryPrefix == "" {
+		m.DiscoveryPrefix = "homeassistant"
+	}
+	if m.DeviceName == "" {
+		m.DeviceName = "Observatory Weather"
+	}
+	return nil
+}
+
+// initMQTT publishes every update and safety change to the broker
+func initMQTT() {
+	if config.MQTT.Broker == "" {
+		return
+	}
+	mqttMessages = make(chan mqttMessage, 100)
+
+	onWeatherUpdate(func(data WeatherData) {
+		queueMQTT(mqttStateMessage(data))
+	})
+	onSafetyChange(func(verdict SafetyVerdict) {
+		queueMQTT(mqttSafetyMessage(verdict))
+	})
+	go runMQTT()
+}
+
+func queueMQTT(message mqttMessage) {
+	select {
+	case mqttMessages <- message:
+	default:
+		log.Printf("MQTT queue full, dropping message for %s", message.Topic)
+	}
+}
+
+func mqttStateMessage(data WeatherData) mqttMessage {
+	payload, _ := json.Marshal(data)
+	return mqttMessage{Topic: config.MQTT.TopicPrefix + "/state", Payload: payload, Retain: true}
+}
+
+func mqttSafetyMessage(verdict SafetyVerdict) mqttMessage {
+	payload, _ := json.Marshal(verdict)
+	return mqttMessage{Topic: config.MQTT.TopicPrefix + "/safety", Payload: payload, Retain: true}
+}
+
+// runMQTT keeps a broker connection open, reconnecting with backoff
+func runMQTT() {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := mqttSession()
+		if time.Since(started) > mqttKeepAlive {
+			backoff = time.Second
+		}
+		log.Printf("MQTT connection to %s lost, reconnecting in %s: %v", config.MQTT.Broker, backoff, redactText(err.Error()))
+		time.Sleep(backoff)
+		backoff = min(2*backoff, 5*time.Minute)
+	}
+}
+
+// mqttSession connects, announces the device and publishes queued messages
+// until the connection fails
+func mqttSession() error {
+	m := config.MQTT
+	u, _ := url.Parse(m.Broker)
+	host := u.Host
+	secure := u.Scheme == "ssl" || u.Scheme == "tls" || u.Scheme == "mqtts"
+	if u.Port() == "" {
+		if secure {
+			host = net.JoinHostPort(host, "8883")
+		} else {
+			host = net.JoinHostPort(host, "1883")
+		}
+	}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if secure {
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	availability := m.TopicPrefix + "/availability"
+	if err := mqttConnect(conn, availability); err != nil {
+		return err
+	}
+	log.Printf("Connected to MQTT broker %s", m.Broker)
+
+	// Watch for the broker closing the connection; PINGRESPs are discarded
+	closed := make(chan error, 1)
+	go func() {
+		reader := bufio.NewReader(conn)
+		for {
+			if _, _, err := readMQTTPacket(reader); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+
+	publish := func(message mqttMessage) error {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		_, err := conn.Write(mqttPublishPacket(message))
+		return err
+	}
+
+	// Announce availability, discovery configs and the current state, which
+	// supersedes anything queued while disconnected
+	for len(mqttMessages) > 0 {
+		<-mqttMessages
+	}
+	announce := []mqttMessage{{Topic: availability, Payload: []byte("online"), Retain: true}}
+	if !m.DisableDiscovery {
+		announce = append(announce, haDiscoveryMessages()...)
+	}
+	if data := getWeatherData(); data.Sensors != nil {
+		announce = append(announce, mqttStateMessage(data))
+	}
+	announce = append(announce, mqttSafetyMessage(currentSafety()))
+	for _, message := range announce {
+		if err := publish(message); err != nil {
+			return err
+		}
+	}
+
+	ping := time.NewTicker(mqttKeepAlive / 2)
+	defer ping.Stop()
+	for {
+		select {
+		case message := <-mqttMessages:
+			if err := publish(message); err != nil {
+				return err
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if _, err := conn.Write([]byte{0xC0, 0x00}); err != nil {
+				return err
+			}
+		case err := <-closed:
+			return err
+		}
+	}
+}
+
+// mqttConnect sends CONNECT with an "offline" last will and waits for CONNACK
+func mqttConnect(conn net.Conn, willTopic string) error {
+	m := config.MQTT
+	flags := byte(0x02)  // Clean session
+	flags |= 0x04 | 0x20 // Will flag, will retain (QoS 0)
+	var payload []byte
+	payload = appendMQTTString(payload, m.ClientID)
+	payload = appendMQTTString(payload, willTopic)
+	payload = appendMQTTString(payload, "offline")
+	if m.Username != "" {
+		flags |= 0x80
+		payload = appendMQTTString(payload, m.Username)
+		if m.Password != "" {
+			flags |= 0x40
+			payload = appendMQTTString(payload, m.Password)
+		}
+	}
+
+	var variable []byte
+	variable = appendMQTTString(variable, "MQTT")
+	keepAlive := uint16(mqttKeepAlive.Seconds())
+	variable = append(variable, 0x04, flags, byte(keepAlive>>8), byte(keepAlive))
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+	if _, err := conn.Write(mqttPacket(0x10, append(variable, payload...))); err != nil {
+		return err
+	}
+
+	packetType, body, err := readMQTTPacket(bufio.NewReader(conn))
+	if err != nil {
+		return err
+	}
+	if packetType != 0x20 || len(body) < 2 {
+		return fmt.Errorf("unexpected MQTT packet 0x%02x waiting for CONNACK", packetType)
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("MQTT broker refused connection with code %d", body[1])
+	}
+	return nil
+}
+
+func mqttPublishPacket(message mqttMessage) []byte {
+	header := byte(0x30) // PUBLISH, QoS 0
+	if message.Retain {
+		header |= 0x01
+	}
+	body := appendMQTTString(nil, message.Topic)
+	return mqttPacket(header, append(body, message.Payload...))
+}
+
+// mqttPacket frames a packet body with its fixed header
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	return append(append(b, byte(len(s)>>8), byte(len(s))), s...)
+}
+
+// readMQTTPacket reads one packet, returning its type nibble and body
+func readMQTTPacket(reader *bufio.Reader) (byte, []byte, error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		digit, err := reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7F) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("malformed MQTT remaining length")
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return 0, nil, err
+	}
+	return header & 0xF0, body, nil
+}
+
+var nodeIDPattern = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// haDiscoveryMessages builds the retained Home Assistant discovery configs
+func haDiscoveryMessages() []mqttMessage {
+	m := config.MQTT
+	nodeID := nodeIDPattern.ReplaceAllString(m.ClientID, "_")
+	device := map[string]interface{}{
+		"identifiers":  []string{nodeID},
+		"name":         m.DeviceName,
+		"manufacturer": "GregOberfield",
+		"model":        "Boltwood II Alpaca Driver",
+		"sw_version":   driverVersion,
+	}
+
+	discovery := func(component, key string, fields map[string]interface{}) mqttMessage {
+		fields["unique_id"] = nodeID + "_" + key
+		fields["availability_topic"] = m.TopicPrefix + "/availability"
+		fields["device"] = device
+		payload, _ := json.Marshal(fields)
+		return mqttMessage{
+			Topic:   fmt.Sprintf("%s/%s/%s/%s/config", m.DiscoveryPrefix, component, nodeID, key),
+			Payload: payload,
+			Retain:  true,
+		}
+	}
+
+	var messages []mqttMessage
+	for _, sensor := range haSensors {
+		fields := map[string]interface{}{
+			"name":           sensor.Name,
+			"state_topic":    m.TopicPrefix + "/state",
+			"value_template": fmt.Sprintf("{{ value_json.%s }}", sensor.Key),
+		}
+		if sensor.Template != "" {
+			fields["value_template"] = sensor.Template
+		}
+		if sensor.DeviceClass != "" {
+			fields["device_class"] = sensor.DeviceClass
+		}
+		if sensor.Unit != "" {
+			fields["unit_of_measurement"] = sensor.Unit
+			fields["state_class"] = "measurement"
+		}
+		messages = append(messages, discovery(sensor.Component, sensor.Key, fields))
+	}
+
+	// Home Assistant's safety class reads ON as unsafe
+	messages = append(messages, discovery("binary_sensor", "safety", map[string]interface{}{
+		"name":                  "Observing safety",
+		"state_topic":           m.TopicPrefix + "/safety",
+		"value_template":        "{{ 'OFF' if value_json.safe else 'ON' }}",
+		"device_class":          "safety",
+		"json_attributes_topic": m.TopicPrefix + "/safety",
+	}))
+	return messages
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestMQTTPacketRemainingLength(t *testing.T) {
+	tests := []struct {
+		length int
+		header []byte
+	}{
+		{0, []byte{0x30, 0x00}},
+		{127, []byte{0x30, 0x7F}},
+		{128, []byte{0x30, 0x80, 0x01}},
+		{16383, []byte{0x30, 0xFF, 0x7F}},
+		{16384, []byte{0x30, 0x80, 0x80, 0x01}},
+		{2097152, []byte{0x30, 0x80, 0x80, 0x80, 0x01}},
+	}
+	for _, tt := range tests {
+		body := bytes.Repeat([]byte{'x'}, tt.length)
+		packet := mqttPacket(0x30, body)
+		if !bytes.HasPrefix(packet, tt.header) || len(packet) != len(tt.header)+tt.length {
+			t.Errorf("length %d: header % x, want % x", tt.length, packet[:min(len(packet), 5)], tt.header)
+			continue
+		}
+
+		packetType, got, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(packet)))
+		if err != nil || packetType != 0x30 || !bytes.Equal(got, body) {
+			t.Errorf("length %d: read back type 0x%02x, %d bytes, %v", tt.length, packetType, len(got), err)
+		}
+	}
+
+	malformed := []byte{0x30, 0x80, 0x80, 0x80, 0x80, 0x01}
+	if _, _, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(malformed))); err == nil {
+		t.Error("expected an error for a five byte remaining length")
+	}
+}
+
+func TestMQTTPublishPacket(t *testing.T) {
+	tests := []struct {
+		message mqttMessage
+		want    []byte
+	}{
+		{mqttMessage{Topic: "a/b", Payload: []byte("on")}, []byte{0x30, 7, 0, 3, 'a', '/', 'b', 'o', 'n'}},
+		{mqttMessage{Topic: "a/b", Payload: []byte("on"), Retain: true}, []byte{0x31, 7, 0, 3, 'a', '/', 'b', 'o', 'n'}},
+	}
+	for _, tt := range tests {
+		if got := mqttPublishPacket(tt.message); !bytes.Equal(got, tt.want) {
+			t.Errorf("mqttPublishPacket(%+v) = % x, want % x", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestMQTTConnect(t *testing.T) {
+	tests := []struct {
+		name      string
+		username  string
+		password  string
+		flags     byte
+		returnErr byte
+	}{
+		{"anonymous", "", "", 0x26, 0},
+		{"credentials", "user", "pass", 0xE6, 0},
+		{"refused", "user", "", 0xA6, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config = Config{MQTT: MQTTConfig{ClientID: "wd", Username: tt.username, Password: tt.password}}
+			client, broker := net.Pipe()
+			defer client.Close()
+
+			connect := make(chan []byte, 1)
+			go func() {
+				defer broker.Close()
+				_, body, err := readMQTTPacket(bufio.NewReader(broker))
+				if err != nil {
+					connect <- nil
+					return
+				}
+				connect <- body
+				broker.Write(mqttPacket(0x20, []byte{0, tt.returnErr}))
+			}()
+
+			err := mqttConnect(client, "wd/availability")
+			body := <-connect
+			if len(body) < 10 || string(body[2:6]) != "MQTT" || body[6] != 0x04 {
+				t.Fatalf("CONNECT variable header % x", body)
+			}
+			if body[7] != tt.flags {
+				t.Errorf("flags = 0x%02x, want 0x%02x", body[7], tt.flags)
+			}
+			if !bytes.Contains(body, []byte("wd/availability")) || !bytes.Contains(body, []byte("offline")) {
+				t.Errorf("CONNECT lacks the last will: %q", body)
+			}
+			if (err != nil) != (tt.returnErr != 0) {
+				t.Errorf("mqttConnect error = %v, broker returned %d", err, tt.returnErr)
+			}
+		})
+	}
+}
+
+func TestHADiscoveryMessages(t *testing.T) {
+	config = Config{MQTT: MQTTConfig{ClientID: "boltwood.1", TopicPrefix: "obs", DiscoveryPrefix: "homeassistant", DeviceName: "Roof"}}
+	messages := haDiscoveryMessages()
+	if len(messages) == 0 {
+		t.Fatal("no discovery messages")
+	}
+	for _, message := range messages {
+		if !message.Retain || !strings.HasPrefix(message.Topic, "homeassistant/") || !strings.Contains(message.Topic, "/boltwood_1/") {
+			t.Errorf("topic %q retain %v", message.Topic, message.Retain)
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(message.Payload, &fields); err != nil {
+			t.Errorf("%s: %v", message.Topic, err)
+		} else if fields["availability_topic"] != "obs/availability" {
+			t.Errorf("%s: availability_topic %v", message.Topic, fields["availability_topic"])
+		}
+	}
+}
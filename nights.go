@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// NightSummary describes the observing conditions of one night, from the end
+// of evening twilight to the start of morning twilight
+type NightSummary struct {
+	Date   string    `json:"date"`   // local date the night began
+	Window string    `json:"window"` // darkest twilight reached: astronomical, nautical or civil
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+
+	Hours      float64 `json:"hours"`      // length of the night
+	DataHours  float64 `json:"dataHours"`  // time covered by samples
+	ClearHours float64 `json:"clearHours"` // time with clear skies
+	SafeHours  float64 `json:"safeHours"`  // time the safety verdict was safe
+	SafePct    float64 `json:"safePercent"`
+
+	// Score is the percentage of the night that was both clear and safe
+	Score float64 `json:"score"`
+
+	// Median sky minus ambient temperature; more negative is clearer
+	SkyAmbientDelta *float64    `json:"skyAmbientDelta,omitempty"`
+	WindSpeed       *NightStats `json:"windSpeed,omitempty"`
+	Humidity        *NightStats `json:"humidity,omitempty"`
+	RainEvents      int         `json:"rainEvents"`
+	Samples         int         `json:"samples"`
+}
+
+type NightStats struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	Max  float64 `json:"max"`
+}
+
+// Cloud cover (%) below which the sky counts as clear when no source reports
+// a cloud condition
+const clearCloudCover = 20
+
+// How many past nights are summarised when missing, such as after downtime
+const nightsCatchUp = 7
+
+var (
+	nightSummaries = make(map[string]NightSummary)
+	nightsMutex    sync.RWMutex
+)
+
+// initNights loads the stored summaries and summarises each night once its
+// dawn has passed. Nights need the site location for their twilight times.
+func initNights() {
+	if !siteConfigured() {
+		return
+	}
+	if config.HistoryDir != "" {
+		if err := loadNightSummaries(); err != nil {
+			log.Printf("Error loading night summaries: %v", err)
+		}
+	}
+	go watchNights()
+}
+
+func nightsPath() string {
+	return filepath.Join(config.HistoryDir, "nights.jsonl")
+}
+
+func loadNightSummaries() error {
+	file, err := os.Open(nightsPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	nightsMutex.Lock()
+	defer nightsMutex.Unlock()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var summary NightSummary
+		if err := json.Unmarshal(scanner.Bytes(), &summary); err != nil {
+			continue
+		}
+		nightSummaries[summary.Date] = summary
+	}
+	return scanner.Err()
+}
+
+func saveNightSummary(summary NightSummary) error {
+	nightsMutex.Lock()
+	nightSummaries[summary.Date] = summary
+	nightsMutex.Unlock()
+
+	if config.HistoryDir == "" {
+		return nil
+	}
+	line, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(nightsPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+func watchNights() {
+	for {
+		summarizeNights(time.Now())
+		time.Sleep(5 * time.Minute)
+	}
+}
+
+// summarizeNights summarises the recent nights that have ended and have no
+// summary yet
+func summarizeNights(now time.Time) {
+	for days := nightsCatchUp; days >= 0; days-- {
+		date, start, end, window, ok := nightWindow(now.AddDate(0, 0, -days))
+		if !ok || end.After(now) {
+			continue
+		}
+		nightsMutex.RLock()
+		_, done := nightSummaries[date]
+		nightsMutex.RUnlock()
+		if done {
+			continue
+		}
+
+		samples := queryHistory(start, end)
+		if len(samples) == 0 {
+			continue
+		}
+		summary := summarizeNight(samples, start, end)
+		summary.Date, summary.Window = date, window
+		if err := saveNightSummary(summary); err != nil {
+			log.Printf("Error saving night summary: %v", err)
+		}
+		log.Printf("Night of %s: score %.0f, %.1f clear hours, %.0f%% safe", date, summary.Score, summary.ClearHours, summary.SafePct)
+	}
+}
+
+// nightWindow returns the night between the local noons around t, using the
+// darkest twilight the sun reaches. It reports false when the sun stays up.
+func nightWindow(t time.Time) (date string, start, end time.Time, window string, ok bool) {
+	loc, err := time.LoadLocation(config.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	night := nightEvents(t)
+	for _, w := range []struct {
+		name       string
+		dusk, dawn *time.Time
+	}{
+		{"astronomical", night.AstronomicalDusk, night.AstronomicalDawn},
+		{"nautical", night.NauticalDusk, night.NauticalDawn},
+		{"civil", night.CivilDusk, night.CivilDawn},
+	} {
+		if w.dusk != nil && w.dawn != nil {
+			return w.dusk.In(loc).Format(segmentDateFormat), *w.dusk, *w.dawn, w.name, true
+		}
+	}
+	return "", time.Time{}, time.Time{}, "", false
+}
+
+// summarizeNight computes the statistics of the samples between start and
+// end. Each sample counts until the next one, but for no longer than
+// MaxDataAge (or the compaction step of older history), so gaps in the data
+// count as neither clear nor safe.
+func summarizeNight(samples []WeatherData, start, end time.Time) NightSummary {
+	maxGap, _ := time.ParseDuration(config.Safety.MaxDataAge)
+	if step, _ := time.ParseDuration(config.CompactStep); step > maxGap {
+		maxGap = step
+	}
+
+	summary := NightSummary{Start: start, End: end, Samples: len(samples)}
+	summary.Hours = end.Sub(start).Hours()
+
+	var deltas, wind, humidity []float64
+	var usableHours float64
+	raining := false
+	for i, sample := range samples {
+		next := end
+		if i+1 < len(samples) {
+			next = samples[i+1].Date
+		}
+		weight := next.Sub(sample.Date)
+		if weight > maxGap {
+			weight = maxGap
+		}
+		hours := weight.Hours()
+
+		summary.DataHours += hours
+		clear := sampleClear(sample)
+		if clear {
+			summary.ClearHours += hours
+		}
+		if sample.Safe {
+			summary.SafeHours += hours
+		}
+		if clear && sample.Safe {
+			usableHours += hours
+		}
+
+		if hasSensors(sample, "skyTemperature", "ambientTemperature") {
+			deltas = append(deltas, sample.SkyTemperature-sample.AmbientTemperature)
+		}
+		if hasSensors(sample, "windSpeed") {
+			wind = append(wind, sample.WindSpeed)
+		}
+		if hasSensors(sample, "humidity") {
+			humidity = append(humidity, sample.Humidity)
+		}
+
+		rain := sample.RainFlag != 0 || sample.RainCondition == parseRainCondition(3)
+		if rain && !raining {
+			summary.RainEvents++
+		}
+		raining = rain
+	}
+
+	if summary.Hours > 0 {
+		summary.SafePct = math.Round(summary.SafeHours/summary.Hours*1000) / 10
+		summary.Score = math.Round(usableHours/summary.Hours*1000) / 10
+	}
+	summary.Hours = roundHundredths(summary.Hours)
+	summary.DataHours = roundHundredths(summary.DataHours)
+	summary.ClearHours = roundHundredths(summary.ClearHours)
+	summary.SafeHours = roundHundredths(summary.SafeHours)
+	if len(deltas) > 0 {
+		median := roundHundredths(medianValue(deltas))
+		summary.SkyAmbientDelta = &median
+	}
+	summary.WindSpeed = nightStats(wind)
+	summary.Humidity = nightStats(humidity)
+	return summary
+}
+
+// sampleClear reports clear skies from the cloud condition, or from the cloud
+// cover estimate when no source reports a condition
+func sampleClear(sample WeatherData) bool {
+	if hasSensors(sample, "cloudCondition") {
+		return sample.CloudCondition == parseCloudCondition(1)
+	}
+	return hasSensors(sample, "cloudCover") && sample.CloudCover < clearCloudCover
+}
+
+func nightStats(values []float64) *NightStats {
+	if len(values) == 0 {
+		return nil
+	}
+	stats := NightStats{Min: values[0], Max: values[0]}
+	var sum float64
+	for _, v := range values {
+		stats.Min = math.Min(stats.Min, v)
+		stats.Max = math.Max(stats.Max, v)
+		sum += v
+	}
+	stats.Mean = roundHundredths(sum / float64(len(values)))
+	return &stats
+}
+
+// recentNights returns up to count summaries, newest first
+func recentNights(count int) []NightSummary {
+	nightsMutex.RLock()
+	defer nightsMutex.RUnlock()
+	nights := make([]NightSummary, 0, len(nightSummaries))
+	for _, summary := range nightSummaries {
+		nights = append(nights, summary)
+	}
+	sort.Slice(nights, func(i, j int) bool { return nights[i].Date > nights[j].Date })
+	if len(nights) > count {
+		nights = nights[:count]
+	}
+	return nights
+}
+
+// handleNightsAPI serves the most recent night summaries, 30 unless the count
+// parameter says otherwise
+func handleNightsAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !siteConfigured() {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "site latitude and longitude are not configured"})
+		return
+	}
+
+	count := 30
+	if value := r.URL.Query().Get("count"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("invalid count %q", value)})
+			return
+		}
+		count = n
+	}
+	json.NewEncoder(w).Encode(recentNights(count))
+}
+
+// handleNights renders the recent nights as a table
+func handleNights(w http.ResponseWriter, r *http.Request) {
+	t, err := template.New("nights.html").Funcs(template.FuncMap{
+		"localTime": func(t time.Time) string {
+			loc, err := time.LoadLocation(config.Timezone)
+			if err != nil {
+				loc = time.UTC
+			}
+			return t.In(loc).Format("15:04")
+		},
+		"deref": func(v *float64) float64 { return *v },
+	}).ParseFS(templateFiles, "templates/nights.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		SiteConfigured bool
+		Nights         []NightSummary
+	}{siteConfigured(), recentNights(30)}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := t.Execute(w, data); err != nil {
+		log.Printf("Error executing template: %v", err)
+	}
+}
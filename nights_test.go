@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testNight is 20:00 to 04:00: two clear hours, two cloudy hours with a
+// shower, an hour without data, then three hours clear by cloud cover with a
+// rain flag at 02:00
+func testNight() ([]WeatherData, time.Time, time.Time) {
+	start := time.Date(2024, 12, 21, 20, 0, 0, 0, time.UTC)
+	end := start.Add(8 * time.Hour)
+	sensors := func(keys ...string) map[string]SensorInfo {
+		m := make(map[string]SensorInfo)
+		for _, key := range keys {
+			m[key] = SensorInfo{Source: "roof"}
+		}
+		return m
+	}
+
+	var samples []WeatherData
+	for t := start; t.Before(end); t = t.Add(5 * time.Minute) {
+		elapsed := t.Sub(start)
+		var sample WeatherData
+		switch {
+		case elapsed < 2*time.Hour:
+			sample = WeatherData{CloudCondition: "Clear", SkyTemperature: -20, AmbientTemperature: 5, WindSpeed: 2, Humidity: 60, Safe: true,
+				Sensors: sensors("cloudCondition", "skyTemperature", "ambientTemperature", "windSpeed", "humidity")}
+		case elapsed < 4*time.Hour:
+			shower := elapsed >= 3*time.Hour && elapsed < 3*time.Hour+20*time.Minute
+			sample = WeatherData{CloudCondition: "Light Clouds", SkyTemperature: -5, AmbientTemperature: 5, Humidity: 80, Safe: !shower,
+				Sensors: sensors("cloudCondition", "skyTemperature", "ambientTemperature", "humidity")}
+			if shower {
+				sample.RainCondition = "Rain"
+			}
+		case elapsed < 5*time.Hour:
+			continue
+		default:
+			sample = WeatherData{CloudCover: 10, Humidity: 90, Safe: true, Sensors: sensors("cloudCover", "humidity")}
+			if elapsed == 6*time.Hour {
+				sample.RainFlag, sample.Safe = 1, false
+			}
+		}
+		sample.Date = t
+		samples = append(samples, sample)
+	}
+	return samples, start, end
+}
+
+func TestSummarizeNight(t *testing.T) {
+	config = Config{Safety: SafetyConfig{MaxDataAge: "10m"}}
+	samples, start, end := testNight()
+	summary := summarizeNight(samples, start, end)
+
+	// The last sample before the gap counts for MaxDataAge
+	if summary.Hours != 8 || summary.DataHours != 7.08 || summary.Samples != 84 {
+		t.Errorf("%v hours, %v with data from %d samples; want 8, 7.08 from 84", summary.Hours, summary.DataHours, summary.Samples)
+	}
+	if summary.ClearHours != 5 {
+		t.Errorf("ClearHours = %v, want 5", summary.ClearHours)
+	}
+	if summary.SafeHours != 6.67 || summary.SafePct != 83.3 {
+		t.Errorf("SafeHours = %v (%v%%), want 6.67 (83.3%%)", summary.SafeHours, summary.SafePct)
+	}
+	// Clear and safe for 4h55m of 8h
+	if summary.Score != 61.5 {
+		t.Errorf("Score = %v, want 61.5", summary.Score)
+	}
+	if summary.RainEvents != 2 {
+		t.Errorf("RainEvents = %d, want 2", summary.RainEvents)
+	}
+	if summary.SkyAmbientDelta == nil || *summary.SkyAmbientDelta != -17.5 {
+		t.Errorf("SkyAmbientDelta = %v, want -17.5", summary.SkyAmbientDelta)
+	}
+	if w := summary.WindSpeed; w == nil || *w != (NightStats{Min: 2, Mean: 2, Max: 2}) {
+		t.Errorf("WindSpeed = %+v", w)
+	}
+	if h := summary.Humidity; h == nil || *h != (NightStats{Min: 60, Mean: 78.57, Max: 90}) {
+		t.Errorf("Humidity = %+v", h)
+	}
+
+	// Compacted history counts each sample for the compaction step
+	config.CompactStep = "30m"
+	if summary := summarizeNight(samples, start, end); summary.DataHours != 7.42 {
+		t.Errorf("DataHours with a 30m compaction step = %v, want 7.42", summary.DataHours)
+	}
+}
+
+func TestSummarizeEmptyNight(t *testing.T) {
+	config = Config{Safety: SafetyConfig{MaxDataAge: "10m"}}
+	start := time.Date(2024, 12, 21, 20, 0, 0, 0, time.UTC)
+	summary := summarizeNight(nil, start, start.Add(8*time.Hour))
+	if summary.Score != 0 || summary.SafePct != 0 || summary.SkyAmbientDelta != nil || summary.WindSpeed != nil {
+		t.Errorf("empty night %+v", summary)
+	}
+}
+
+func TestNightWindow(t *testing.T) {
+	tests := []struct {
+		name     string
+		latitude float64
+		time     string
+		date     string
+		window   string
+		ok       bool
+	}{
+		{"Greenwich in December", 51.4779, "2024-12-22T03:00:00Z", "2024-12-21", "astronomical", true},
+		{"Greenwich at midsummer", 51.4779, "2024-06-21T15:00:00Z", "2024-06-21", "nautical", true},
+		{"midnight sun", 70, "2024-06-21T15:00:00Z", "", "", false},
+	}
+	for _, tt := range tests {
+		setTestSite(tt.latitude, 0, "Europe/London")
+		date, start, end, window, ok := nightWindow(mustParseTime(t, tt.time))
+		if date != tt.date || window != tt.window || ok != tt.ok {
+			t.Errorf("%s: night of %q, %s window, %v; want %q, %s, %v", tt.name, date, window, ok, tt.date, tt.window, tt.ok)
+		}
+		if ok && !end.After(start) {
+			t.Errorf("%s: night from %v to %v", tt.name, start, end)
+		}
+	}
+}
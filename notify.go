@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// NotifierConfig describes a destination for condition change notifications
+type NotifierConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // webhook, ntfy, gotify or smtp
+	URL  string `json:"url"`  // Webhook URL, ntfy topic URL or Gotify server URL
+
+	// ntfy access token or Gotify application token
+	Token    string `json:"token"`
+	Priority int    `json:"priority"`
+
+	// SMTP server as host:port, with optional PLAIN authentication
+	SMTPServer string   `json:"smtpServer"`
+	Username   string   `json:"username"`
+	Password   string   `json:"password"`
+	From       string   `json:"from"`
+	To         []string `json:"to"`
+
+	// Events to send; all when empty
+	Events []string `json:"events"`
+
+	// text/template overrides for the title and message of every event
+	Title   string `json:"title"`
+	Message string `json:"message"`
+
+	// Minimum time between notifications of the same event. Events arriving
+	// sooner are held back and only the latest is sent once it has passed.
+	MinInterval string `json:"minInterval"`
+
+	// Local time range, such as "23:00-07:00", during which nothing is sent
+	QuietHours string `json:"quietHours"`
+}
+
+// Notification is a condition change, passed to the message templates and
+// sent as JSON to webhooks
+type Notification struct {
+	Event    string        `json:"event"`
+	Title    string        `json:"title"`
+	Message  string        `json:"message"`
+	Time     time.Time     `json:"time"`
+	Previous string        `json:"previous,omitempty"`
+	Current  string        `json:"current,omitempty"`
+	Source   string        `json:"source,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Safety   SafetyVerdict `json:"safety"`
+	Weather  WeatherData   `json:"weather"`
+}
+
+// Default title and message templates by event
+var notificationTemplates = map[string][2]string{
+	"safety": {
+		`Conditions {{if .Safety.Safe}}safe{{else}}unsafe{{end}}`,
+		`{{if .Safety.Safe}}Conditions are now safe to observe{{else}}Conditions are now unsafe: {{join .Safety.Reasons "; "}}{{end}}`,
+	},
+	"rain": {
+		`Rain: {{.Current}}`,
+		`Rain condition changed from {{.Previous}} to {{.Current}}`,
+	},
+	"cloud": {
+		`Clouds: {{.Current}}`,
+		`Cloud condition changed from {{.Previous}} to {{.Current}}`,
+	},
+	"alert": {
+		`Alert status: {{.Current}}`,
+		`Alert status changed from {{.Previous}} to {{.Current}}`,
+	},
+	"stale": {
+		`Weather data {{.Current}}`,
+		`{{if eq .Current "stale"}}No weather data since {{.Weather.Date.Format "2006-01-02 15:04:05 MST"}}{{else}}Weather data is updating again{{end}}`,
+	},
+	"sourceError": {
+		`Source {{.Source}} failing`,
+		`Polling source {{.Source}} failed: {{.Error}}`,
+	},
+}
+
+var notificationFuncs = template.FuncMap{"join": strings.Join}
+
+// defaultTemplates holds the title and message templates of each event,
+// parsed once from notificationTemplates
+var defaultTemplates = parseDefaultTemplates()
+
+func parseDefaultTemplates() map[string][2]*template.Template {
+	parsed := make(map[string][2]*template.Template, len(notificationTemplates))
+	for event, texts := range notificationTemplates {
+		parsed[event] = [2]*template.Template{
+			template.Must(template.New("title").Funcs(notificationFuncs).Parse(texts[0])),
+			template.Must(template.New("message").Funcs(notificationFuncs).Parse(texts[1])),
+		}
+	}
+	return parsed
+}
+
+type notifier struct {
+	config   NotifierConfig
+	events   map[string]bool
+	title    *template.Template
+	message  *template.Template
+	queue    chan Notification
+	lastSent map[string]time.Time
+	held     map[string]Notification
+}
+
+var notifiers []*notifier
+
+func validateNotifiers() error {
+	names := make(map[string]bool)
+	for i := range config.Notifiers {
+		n := &config.Notifiers[i]
+		if n.Name == "" {
+			n.Name = fmt.Sprintf("%s-%d", n.Type, i)
+		}
+		if names[n.Name] {
+			return fmt.Errorf("duplicate notifier name %q", n.Name)
+		}
+		names[n.Name] = true
+
+		switch n.Type {
+		case "webhook", "ntfy", "gotify":
+			if n.URL == "" {
+				return fmt.Errorf("notifier %s has no url", n.Name)
+			}
+		case "smtp":
+			if n.SMTPServer == "" || n.From == "" || len(n.To) == 0 {
+				return fmt.Errorf("notifier %s needs smtpServer, from and to", n.Name)
+			}
+		default:
+			return fmt.Errorf("notifier %s has unknown type %q", n.Name, n.Type)
+		}
+
+		for _, event := range n.Events {
+			if _, ok := notificationTemplates[event]; !ok {
+				return fmt.Errorf("notifier %s has unknown event %q", n.Name, event)
+			}
+		}
+		for _, text := range []string{n.Title, n.Message} {
+			if _, err := template.New("").Funcs(notificationFuncs).Parse(text); err != nil {
+				return fmt.Errorf("invalid template for notifier %s: %v", n.Name, err)
+			}
+		}
+
+		if n.MinInterval == "" {
+			n.MinInterval = "5m"
+		}
+		interval, err := time.ParseDuration(n.MinInterval)
+		if err != nil || interval < 0 {
+			return fmt.Errorf("invalid MinInterval for notifier %s: %q", n.Name, n.MinInterval)
+		}
+		n.MinInterval = interval.String()
+
+		if n.QuietHours != "" {
+			if _, _, err := parseQuietHours(n.QuietHours); err != nil {
+				return fmt.Errorf("invalid QuietHours for notifier %s: %v", n.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// parseQuietHours parses "HH:MM-HH:MM" into minutes after midnight
+func parseQuietHours(value string) (int, int, error) {
+	var startHour, startMinute, endHour, endMinute int
+	if _, err := fmt.Sscanf(value, "%d:%d-%d:%d", &startHour, &startMinute, &endHour, &endMinute); err != nil {
+		return 0, 0, fmt.Errorf("expected HH:MM-HH:MM, got %q", value)
+	}
+	for _, v := range []int{startHour, endHour} {
+		if v < 0 || v > 23 {
+			return 0, 0, fmt.Errorf("hour out of range in %q", value)
+		}
+	}
+	for _, v := range []int{startMinute, endMinute} {
+		if v < 0 || v > 59 {
+			return 0, 0, fmt.Errorf("minute out of range in %q", value)
+		}
+	}
+	return startHour*60 + startMinute, endHour*60 + endMinute, nil
+}
+
+// initNotifiers starts the notifiers and the watchers raising their events
+func initNotifiers() {
+	if len(config.Notifiers) == 0 {
+		return
+	}
+	for _, notifierConfig := range config.Notifiers {
+		n := &notifier{
+			config:   notifierConfig,
+			events:   make(map[string]bool),
+			queue:    make(chan Notification, 100),
+			lastSent: make(map[string]time.Time),
+			held:     make(map[string]Notification),
+		}
+		for _, event := range notifierConfig.Events {
+			n.events[event] = true
+		}
+		if notifierConfig.Title != "" {
+			n.title = template.Must(template.New("title").Funcs(notificationFuncs).Parse(notifierConfig.Title))
+		}
+		if notifierConfig.Message != "" {
+			n.message = template.Must(template.New("message").Funcs(notificationFuncs).Parse(notifierConfig.Message))
+		}
+		notifiers = append(notifiers, n)
+		go n.run()
+	}
+
+	// initialSafety and previous are each used only by the one listener
+	// capturing them, and safety listeners all run on the watchSafety
+	// goroutine and weather listeners on the polling goroutine, so neither
+	// needs a lock.
+
+	// The first verdict is the starting state, not a change
+	initialSafety := true
+	onSafetyChange(func(verdict SafetyVerdict) {
+		if initialSafety {
+			initialSafety = false
+			return
+		}
+		notify(Notification{Event: "safety", Safety: verdict})
+	})
+
+	// Condition changes, relative to the first value seen
+	conditions := map[string]string{
+		"rainCondition":  "rain",
+		"cloudCondition": "cloud",
+		"alertStatus":    "alert",
+	}
+	previous := make(map[string]string)
+	onWeatherUpdate(func(data WeatherData) {
+		for key, event := range conditions {
+			if _, ok := data.Sensors[key]; !ok {
+				continue
+			}
+			current, _ := weatherFieldString(&data, key)
+			if last, seen := previous[key]; seen && last != current {
+				notify(Notification{Event: event, Previous: last, Current: current})
+			}
+			previous[key] = current
+		}
+	})
+
+	// Sources that start failing, not every failed poll
+	onSourceError(func(source string, err error) {
+		if getSourceHealth()[source].ConsecutiveFailures == 1 {
+			notify(Notification{Event: "sourceError", Source: source, Error: redactText(err.Error())})
+		}
+	})
+
+	go watchStaleData()
+}
+
+// watchStaleData raises "stale" when the data stops updating and again when
+// it recovers
+func watchStaleData() {
+	maxAge, _ := time.ParseDuration(config.Safety.MaxDataAge)
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	stale := false
+	for range ticker.C {
+		date := getWeatherData().Date
+		if date.IsZero() {
+			continue
+		}
+		if isStale := time.Since(date) > maxAge; isStale != stale {
+			stale = isStale
+			current := "fresh"
+			if stale {
+				current = "stale"
+			}
+			notify(Notification{Event: "stale", Current: current})
+		}
+	}
+}
+
+// notify completes a notification with the current state and queues it for
+// every notifier subscribed to its event
+func notify(n Notification) {
+	n.Time = time.Now()
+	n.Weather = getWeatherData()
+	if n.Event != "safety" {
+		n.Safety = currentSafety()
+	}
+	for _, notifier := range notifiers {
+		if len(notifier.events) > 0 && !notifier.events[n.Event] {
+			continue
+		}
+		select {
+		case notifier.queue <- n:
+		default:
+			log.Printf("Notifier %s queue full, dropping %s notification", notifier.config.Name, n.Event)
+		}
+	}
+}
+
+func (n *notifier) run() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case notification := <-n.queue:
+			n.held[notification.Event] = notification
+		case <-ticker.C:
+		}
+		n.sendHeld()
+	}
+}
+
+// sendHeld sends held notifications whose event is outside its rate limit
+func (n *notifier) sendHeld() {
+	interval, _ := time.ParseDuration(n.config.MinInterval)
+	now := time.Now()
+	for event, notification := range n.held {
+		if now.Sub(n.lastSent[event]) < interval {
+			continue
+		}
+		delete(n.held, event)
+		n.lastSent[event] = now
+
+		if n.inQuietHours(now) {
+			log.Printf("Notifier %s in quiet hours, not sending %s notification", n.config.Name, event)
+			continue
+		}
+		if err := n.render(&notification); err != nil {
+			log.Printf("Error rendering %s notification for %s: %v", event, n.config.Name, err)
+			continue
+		}
+		if err := n.send(notification); err != nil {
+			log.Printf("Notifier %s failed to send %s notification: %v", n.config.Name, event, redactText(err.Error()))
+		}
+	}
+}
+
+func (n *notifier) inQuietHours(now time.Time) bool {
+	if n.config.QuietHours == "" {
+		return false
+	}
+	if loc, err := time.LoadLocation(config.Timezone); err == nil {
+		now = now.In(loc)
+	}
+	start, end, _ := parseQuietHours(n.config.QuietHours)
+	minute := now.Hour()*60 + now.Minute()
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end // Across midnight
+}
+
+// render fills in the title and message from the notifier's templates, or
+// the event's defaults
+func (n *notifier) render(notification *Notification) error {
+	defaults := defaultTemplates[notification.Event]
+	title, message := n.title, n.message
+	if title == nil {
+		title = defaults[0]
+	}
+	if message == nil {
+		message = defaults[1]
+	}
+
+	var b strings.Builder
+	if err := title.Execute(&b, notification); err != nil {
+		return err
+	}
+	notification.Title = b.String()
+	b.Reset()
+	if err := message.Execute(&b, notification); err != nil {
+		return err
+	}
+	notification.Message = b.String()
+	return nil
+}
+
+func (n *notifier) send(notification Notification) error {
+	switch n.config.Type {
+	case "webhook":
+		body, _ := json.Marshal(notification)
+		return n.post(n.config.URL, "application/json", body, nil)
+	case "ntfy":
+		headers := map[string]string{"Title": notification.Title}
+		if n.config.Priority > 0 {
+			headers["Priority"] = fmt.Sprint(n.config.Priority)
+		}
+		if n.config.Token != "" {
+			headers["Authorization"] = "Bearer " + n.config.Token
+		}
+		return n.post(n.config.URL, "text/plain; charset=utf-8", []byte(notification.Message), headers)
+	case "gotify":
+		body, _ := json.Marshal(map[string]interface{}{
+			"title":    notification.Title,
+			"message":  notification.Message,
+			"priority": n.config.Priority,
+		})
+		return n.post(strings.TrimRight(n.config.URL, "/")+"/message", "application/json", body,
+			map[string]string{"X-Gotify-Key": n.config.Token})
+	case "smtp":
+		return n.sendMail(notification)
+	}
+	return nil
+}
+
+func (n *notifier) post(url, contentType string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", n.config.Type, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+func (n *notifier) sendMail(notification Notification) error {
+	var auth smtp.Auth
+	if n.config.Username != "" {
+		host, _, err := net.SplitHostPort(n.config.SMTPServer)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", n.config.Username, n.config.Password, host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.config.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.ReplaceAll(notification.Title, "\n", " "))
+	fmt.Fprintf(&msg, "Date: %s\r\n", notification.Time.Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(notification.Message, "\n", "\r\n") + "\r\n")
+
+	return smtp.SendMail(n.config.SMTPServer, auth, n.config.From, n.config.To, msg.Bytes())
+}
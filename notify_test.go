@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// notifyStub records the requests a notifier makes
+type notifyStub struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+}
+
+func (s *notifyStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, string(body))
+}
+
+func newTestNotifier(c NotifierConfig) *notifier {
+	return &notifier{
+		config:   c,
+		events:   make(map[string]bool),
+		lastSent: make(map[string]time.Time),
+		held:     make(map[string]Notification),
+	}
+}
+
+func TestNotifierSend(t *testing.T) {
+	notification := Notification{
+		Event:   "rain",
+		Title:   "Rain: Rain",
+		Message: "Rain condition changed from Dry to Rain",
+		Time:    time.Date(2024, 6, 21, 22, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name    string
+		cfg     NotifierConfig
+		path    string
+		headers map[string]string
+		check   func(t *testing.T, body string)
+	}{
+		{
+			name:    "webhook",
+			cfg:     NotifierConfig{Type: "webhook"},
+			path:    "/hook",
+			headers: map[string]string{"Content-Type": "application/json"},
+			check: func(t *testing.T, body string) {
+				var got Notification
+				if err := json.Unmarshal([]byte(body), &got); err != nil || got.Event != "rain" || got.Message != notification.Message {
+					t.Errorf("webhook body %s (%v)", body, err)
+				}
+			},
+		},
+		{
+			name: "ntfy",
+			cfg:  NotifierConfig{Type: "ntfy", Token: "tk", Priority: 4},
+			path: "/observatory",
+			headers: map[string]string{
+				"Title":         "Rain: Rain",
+				"Priority":      "4",
+				"Authorization": "Bearer tk",
+			},
+			check: func(t *testing.T, body string) {
+				if body != notification.Message {
+					t.Errorf("ntfy body %q", body)
+				}
+			},
+		},
+		{
+			name:    "gotify",
+			cfg:     NotifierConfig{Type: "gotify", Token: "app", Priority: 8},
+			path:    "/message",
+			headers: map[string]string{"X-Gotify-Key": "app"},
+			check: func(t *testing.T, body string) {
+				var got struct {
+					Title    string `json:"title"`
+					Message  string `json:"message"`
+					Priority int    `json:"priority"`
+				}
+				if err := json.Unmarshal([]byte(body), &got); err != nil || got.Title != notification.Title || got.Priority != 8 {
+					t.Errorf("gotify body %s (%v)", body, err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &notifyStub{}
+			server := httptest.NewServer(stub)
+			defer server.Close()
+
+			tt.cfg.URL = server.URL
+			if tt.cfg.Type != "gotify" {
+				tt.cfg.URL += tt.path
+			}
+			if err := newTestNotifier(tt.cfg).send(notification); err != nil {
+				t.Fatal(err)
+			}
+			if len(stub.requests) != 1 {
+				t.Fatalf("%d requests, want 1", len(stub.requests))
+			}
+			r := stub.requests[0]
+			if r.Method != http.MethodPost || r.URL.Path != tt.path {
+				t.Errorf("request %s %s, want POST %s", r.Method, r.URL.Path, tt.path)
+			}
+			for key, want := range tt.headers {
+				if got := r.Header.Get(key); got != want {
+					t.Errorf("header %s = %q, want %q", key, got, want)
+				}
+			}
+			tt.check(t, stub.bodies[0])
+		})
+	}
+}
+
+func TestNotifierSendError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	n := newTestNotifier(NotifierConfig{Type: "ntfy", URL: server.URL})
+	if err := n.send(Notification{}); err == nil {
+		t.Error("expected an error for a 401 response")
+	}
+}
+
+func TestNotifierRateLimit(t *testing.T) {
+	config = Config{Timezone: "UTC"}
+	stub := &notifyStub{}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	n := newTestNotifier(NotifierConfig{Type: "ntfy", URL: server.URL, MinInterval: "1h"})
+	rain := func(current string) Notification {
+		return Notification{Event: "rain", Previous: "Dry", Current: current}
+	}
+
+	n.held["rain"] = rain("Damp")
+	n.sendHeld()
+	if len(stub.bodies) != 1 {
+		t.Fatalf("first notification: %d sent, want 1", len(stub.bodies))
+	}
+
+	// Within the interval only the latest change is kept
+	n.held["rain"] = rain("Rain")
+	n.sendHeld()
+	n.held["rain"] = rain("Damp")
+	n.sendHeld()
+	if len(stub.bodies) != 1 {
+		t.Fatalf("within MinInterval: %d sent, want 1", len(stub.bodies))
+	}
+
+	// Other events have their own interval
+	n.held["cloud"] = Notification{Event: "cloud", Previous: "Clear", Current: "Very Cloudy"}
+	n.sendHeld()
+	if len(stub.bodies) != 2 {
+		t.Fatalf("other event: %d sent, want 2", len(stub.bodies))
+	}
+
+	n.lastSent["rain"] = time.Now().Add(-2 * time.Hour)
+	n.sendHeld()
+	if len(stub.bodies) != 3 || stub.bodies[2] != "Rain condition changed from Dry to Damp" {
+		t.Fatalf("after MinInterval sent %q, want the latest rain change", stub.bodies)
+	}
+}
+
+func TestNotifierQuietHours(t *testing.T) {
+	config = Config{Timezone: "UTC"}
+	at := func(hour, minute int) time.Time { return time.Date(2024, 6, 21, hour, minute, 0, 0, time.UTC) }
+
+	tests := []struct {
+		quietHours string
+		now        time.Time
+		want       bool
+	}{
+		{"", at(3, 0), false},
+		{"23:00-07:00", at(22, 59), false},
+		{"23:00-07:00", at(23, 0), true},
+		{"23:00-07:00", at(0, 30), true},
+		{"23:00-07:00", at(6, 59), true},
+		{"23:00-07:00", at(7, 0), false},
+		{"12:00-13:30", at(13, 15), true},
+		{"12:00-13:30", at(13, 30), false},
+	}
+	for _, tt := range tests {
+		n := newTestNotifier(NotifierConfig{QuietHours: tt.quietHours})
+		if got := n.inQuietHours(tt.now); got != tt.want {
+			t.Errorf("inQuietHours(%q) at %s = %v, want %v", tt.quietHours, tt.now.Format("15:04"), got, tt.want)
+		}
+	}
+
+	// Local time of the configured timezone, not UTC
+	config.Timezone = "America/New_York"
+	n := newTestNotifier(NotifierConfig{QuietHours: "23:00-07:00"})
+	if !n.inQuietHours(at(4, 0)) { // 00:00 EDT
+		t.Error("04:00 UTC should be quiet in New York")
+	}
+	if n.inQuietHours(at(12, 0)) {
+		t.Error("12:00 UTC should not be quiet in New York")
+	}
+}
+
+func TestParseQuietHours(t *testing.T) {
+	tests := []struct {
+		value      string
+		start, end int
+		wantErr    bool
+	}{
+		{"23:00-07:00", 23 * 60, 7 * 60, false},
+		{"0:05-1:10", 5, 70, false},
+		{"24:00-07:00", 0, 0, true},
+		{"23:60-07:00", 0, 0, true},
+		{"late", 0, 0, true},
+	}
+	for _, tt := range tests {
+		start, end, err := parseQuietHours(tt.value)
+		if (err != nil) != tt.wantErr || start != tt.start || end != tt.end {
+			t.Errorf("parseQuietHours(%q) = %d, %d, %v", tt.value, start, end, err)
+		}
+	}
+}
+
+func TestNotifierRender(t *testing.T) {
+	n := newTestNotifier(NotifierConfig{})
+	notification := Notification{Event: "safety", Safety: SafetyVerdict{Reasons: []string{"rainFlag is set", "wetFlag is set"}}}
+	if err := n.render(&notification); err != nil {
+		t.Fatal(err)
+	}
+	if notification.Title != "Conditions unsafe" || notification.Message != "Conditions are now unsafe: rainFlag is set; wetFlag is set" {
+		t.Errorf("rendered %q / %q", notification.Title, notification.Message)
+	}
+}
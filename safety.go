@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SafetyConfig holds the rules deciding whether conditions are safe for
+// observing. Conditions listed for a field, any non-zero flag, or a rule
+// field not updated within MaxDataAge make conditions unsafe, as does the
+// sun rising above MaxSunAltitude degrees when set. Reopen holds conditions
+// unsafe after they were unsafe until the trend settles.
+type SafetyConfig struct {
+	UnsafeConditions map[string][]string `json:"unsafeConditions"`
+	UnsafeFlags      []string            `json:"unsafeFlags"`
+	MaxDataAge       string              `json:"maxDataAge"`
+	MaxSunAltitude   *float64            `json:"maxSunAltitude"`
+	Reopen           ReopenConfig        `json:"reopen"`
+}
+
+// SafetyVerdict is the outcome of evaluating the safety rules
+type SafetyVerdict struct {
+	Safe    bool     `json:"safe"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+func validateSafetyConfig() error {
+	safety := &config.Safety
+	if safety.UnsafeConditions == nil {
+		safety.UnsafeConditions = map[string][]string{
+			"cloudCondition": {"Very Cloudy"},
+			"windCondition":  {"Very Windy"},
+			"rainCondition":  {"Damp", "Rain"},
+			"alertStatus":    {"Alert"},
+		}
+	}
+	if safety.UnsafeFlags == nil {
+		safety.UnsafeFlags = []string{"rainFlag", "wetFlag", "roofCloseFlag"}
+	}
+
+	for key := range safety.UnsafeConditions {
+		if !isRuleField(key) {
+			return fmt.Errorf("unknown field %q in Safety.UnsafeConditions", key)
+		}
+	}
+	var data WeatherData
+	for _, key := range safety.UnsafeFlags {
+		if _, ok := weatherFieldValue(&data, key); !ok || !isRuleField(key) {
+			return fmt.Errorf("unknown flag %q in Safety.UnsafeFlags", key)
+		}
+	}
+	if safety.MaxSunAltitude != nil && !siteConfigured() {
+		return fmt.Errorf("Safety.MaxSunAltitude needs the site latitude and longitude")
+	}
+
+	if safety.MaxDataAge == "" {
+		interval, _ := time.ParseDuration(config.PollingInterval)
+		safety.MaxDataAge = (5 * interval).String()
+	}
+	maxAge, err := time.ParseDuration(safety.MaxDataAge)
+	if err != nil {
+		return fmt.Errorf("invalid Safety.MaxDataAge in config file: %v", err)
+	}
+	safety.MaxDataAge = maxAge.String()
+	return nil
+}
+
+// evaluateSafety applies the safety rules to data as of now. While
+// reopenPending the Reopen rule also holds otherwise safe conditions unsafe.
+func evaluateSafety(data WeatherData, now time.Time, reopenPending bool) SafetyVerdict {
+	if data.Sensors == nil {
+		return SafetyVerdict{Safe: false, Reasons: []string{"No weather data received"}}
+	}
+
+	safety := config.Safety
+	maxAge, _ := time.ParseDuration(safety.MaxDataAge)
+	var reasons []string
+
+	// Every field a rule depends on must be present and fresh
+	var ruleFields []string
+	for key := range safety.UnsafeConditions {
+		ruleFields = append(ruleFields, key)
+	}
+	ruleFields = append(ruleFields, safety.UnsafeFlags...)
+	sort.Strings(ruleFields)
+	for _, key := range ruleFields {
+		info, ok := data.Sensors[key]
+		if !ok {
+			reasons = append(reasons, fmt.Sprintf("No %s data", key))
+		} else if age := now.Sub(info.Updated); age > maxAge {
+			reasons = append(reasons, fmt.Sprintf("Stale %s data (%s old)", key, age.Round(time.Second)))
+		}
+	}
+
+	for _, key := range sortedKeys(safety.UnsafeConditions) {
+		condition, _ := weatherFieldString(&data, key)
+		if containsString(safety.UnsafeConditions[key], condition) {
+			reasons = append(reasons, fmt.Sprintf("%s is %s", key, condition))
+		}
+	}
+
+	for _, key := range safety.UnsafeFlags {
+		if value, _ := weatherFieldValue(&data, key); value != 0 {
+			reasons = append(reasons, fmt.Sprintf("%s is set", key))
+		}
+	}
+
+	// The sun is computed for now rather than read from the sample
+	if safety.MaxSunAltitude != nil {
+		if altitude := sunAltitude(now); altitude > *safety.MaxSunAltitude {
+			reasons = append(reasons, fmt.Sprintf("Sun altitude is %.1f° (above %.1f°)", altitude, *safety.MaxSunAltitude))
+		}
+	}
+
+	if len(reasons) == 0 {
+		if reason := holdReopen(data, now, reopenPending); reason != "" {
+			reasons = append(reasons, reason)
+		}
+	}
+
+	return SafetyVerdict{Safe: len(reasons) == 0, Reasons: reasons}
+}
+
+// safetyListeners are called from watchSafety whenever the verdict flips
+var safetyListeners []func(SafetyVerdict)
+
+func onSafetyChange(listener func(SafetyVerdict)) {
+	safetyListeners = append(safetyListeners, listener)
+}
+
+// startSafetyWatch re-evaluates the verdict on every weather update and once
+// a second, so that data going stale is noticed, notifying listeners of changes
+func startSafetyWatch() {
+	updates := make(chan struct{}, 1)
+	onWeatherUpdate(func(WeatherData) {
+		select {
+		case updates <- struct{}{}:
+		default:
+		}
+	})
+	go watchSafety(updates)
+}
+
+func watchSafety(updates chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	// Reopening is pending from startup and after every unsafe verdict, so a
+	// restart holds the verdict unsafe for the full Reopen delay
+	pending := true
+	var last *SafetyVerdict
+	for {
+		verdict := evaluateSafety(getWeatherData(), time.Now(), pending)
+		pending = !verdict.Safe
+		setReopenPending(pending)
+		if last == nil || verdict.Safe != last.Safe {
+			if verdict.Safe {
+				log.Printf("Conditions are now safe")
+			} else {
+				log.Printf("Conditions are now unsafe: %s", strings.Join(verdict.Reasons, "; "))
+			}
+			for _, listener := range safetyListeners {
+				listener(verdict)
+			}
+		}
+		last = &verdict
+
+		select {
+		case <-updates:
+		case <-ticker.C:
+		}
+	}
+}
+
+// currentSafety evaluates the latest data against the current time, so that
+// data going stale turns conditions unsafe even with no new samples
+func currentSafety() SafetyVerdict {
+	return evaluateSafety(getWeatherData(), time.Now(), isReopenPending())
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -12,15 +13,21 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		// Call the next handler
-		next.ServeHTTP(w, r)
+		// Call the next handler, recording the response for /metrics
+		recorder := &metricsRecorder{
+			ResponseWriter: w,
+			capture:        strings.HasPrefix(r.URL.Path, "/api/v1/"),
+		}
+		next.ServeHTTP(recorder, r)
+		recorder.recordAlpacaMetrics(r)
 
 		// Log the request details
 		log.Printf(
-			"%s %s %s %s",
+			"%s %s %s %d %s",
 			r.RemoteAddr,
 			r.Method,
 			r.RequestURI,
+			recorder.status,
 			time.Since(start),
 		)
 	})
@@ -29,12 +36,22 @@ func loggingMiddleware(next http.Handler) http.Handler {
 func setupRoutes(router *mux.Router) http.Handler {
 	// Wrap the router with the logging middleware
 	loggedRouter := loggingMiddleware(router)
+	router.Use(recordRoute)
 
 	// Existing routes
 	router.HandleFunc("/", handleHome).Methods("GET")
 	router.HandleFunc("/api/weather", handleWeatherAPI).Methods("GET")
+	router.HandleFunc("/api/refresh", handleRefreshAPI).Methods("POST", "PUT")
+	router.HandleFunc("/api/history", handleHistoryAPI).Methods("GET")
+	router.HandleFunc("/api/export", handleExportAPI).Methods("GET")
+	router.HandleFunc("/api/stream", handleStreamAPI).Methods("GET")
+	router.HandleFunc("/api/ephemeris", handleEphemerisAPI).Methods("GET")
+	router.HandleFunc("/api/nights", handleNightsAPI).Methods("GET")
+	router.HandleFunc("/nights", handleNights).Methods("GET")
 	router.HandleFunc("/status", handleStatus).Methods("GET")
+	router.HandleFunc("/metrics", handleMetrics).Methods("GET")
 	router.HandleFunc("/weather", handleWeather).Methods("GET")
+	router.PathPrefix("/static/").Handler(staticHandler()).Methods("GET")
 
 	// Alpaca management API endpoints
 	router.HandleFunc("/management/apiversions", handleAPIVersions).Methods("GET")
@@ -56,6 +73,31 @@ func setupRoutes(router *mux.Router) http.Handler {
 	router.HandleFunc("/api/v1/observingconditions/0/dewpoint", handleDewPoint).Methods("GET")
 	router.HandleFunc("/api/v1/observingconditions/0/windspeed", handleWindSpeed).Methods("GET")
 	router.HandleFunc("/api/v1/observingconditions/0/windspeed", handleWindSpeed).Methods("GET")
+	router.HandleFunc("/api/v1/observingconditions/0/skytemperature", handleSkyTemperature).Methods("GET")
+	router.HandleFunc("/api/v1/observingconditions/0/pressure", handlePressure).Methods("GET")
+	router.HandleFunc("/api/v1/observingconditions/0/skyquality", handleSkyQuality).Methods("GET")
+	router.HandleFunc("/api/v1/observingconditions/0/cloudcover", handleCloudCover).Methods("GET")
+
+	router.HandleFunc("/api/v1/observingconditions/0/refresh", handleRefresh).Methods("PUT")
+
+	// Per-sensor origin of the fused data
+	router.HandleFunc("/api/v1/observingconditions/0/sensordescription", handleSensorDescription).Methods("GET")
+	router.HandleFunc("/api/v1/observingconditions/0/timesincelastupdate", handleTimeSinceLastUpdate).Methods("GET")
+
+	// Roll-off roof, when an actuator is configured
+	if dome != nil {
+		setupDomeRoutes(router)
+	}
+
+	// Weather flag and output switches, when enabled
+	if switches != nil {
+		setupSwitchRoutes(router)
+	}
+
+	// Runtime control of simulator sources
+	if len(simulators) > 0 {
+		router.HandleFunc("/api/simulator", handleSimulatorAPI).Methods("GET", "POST", "DELETE")
+	}
 
 	// Return the logged router instead of the original router
 	return loggedRouter
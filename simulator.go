@@ -0,0 +1,459 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SimulatorScenario describes the weather a simulator source generates. A
+// scenario file is decoded over the defaults, so it need only list what it
+// changes; a zero rate turns that kind of event off.
+type SimulatorScenario struct {
+	Seed int64 `json:"seed"` // random seed; zero for a different run each time
+
+	Temperature      float64 `json:"temperature"`      // daily mean (°C)
+	TemperatureRange float64 `json:"temperatureRange"` // difference between the afternoon high and the pre-dawn low (°C)
+	DewPoint         float64 `json:"dewPoint"`         // °C, held below the ambient temperature
+	WindSpeed        float64 `json:"windSpeed"`        // mean (m/s)
+	GustSpeed        float64 `json:"gustSpeed"`        // largest gust above the mean (m/s)
+
+	CloudsPerHour float64 `json:"cloudsPerHour"` // cloud passages
+	RainPerDay    float64 `json:"rainPerDay"`    // rain showers
+	GustsPerHour  float64 `json:"gustsPerHour"`
+	DropoutRate   float64 `json:"dropoutRate"` // fraction of reads that fail
+
+	// Scripted conditions, timed from when the driver starts and repeated
+	// every Loop when set
+	Events []SimulatorEvent `json:"events"`
+	Loop   string           `json:"loop"`
+}
+
+// SimulatorEvent forces a condition for a while: clear, cloudy, rain, windy,
+// stale (the data stops updating) or dropout (reads fail)
+type SimulatorEvent struct {
+	At        string `json:"at"`
+	For       string `json:"for"`
+	Condition string `json:"condition"`
+}
+
+var simulatorConditions = []string{"clear", "cloudy", "rain", "windy", "stale", "dropout"}
+
+func defaultSimulatorScenario() SimulatorScenario {
+	return SimulatorScenario{
+		Temperature:      10,
+		TemperatureRange: 8,
+		DewPoint:         4,
+		WindSpeed:        3,
+		GustSpeed:        6,
+		CloudsPerHour:    1,
+		RainPerDay:       1,
+		GustsPerHour:     20,
+		DropoutRate:      0.01,
+	}
+}
+
+// simulator is the state of one simulator source
+type simulator struct {
+	mu       sync.Mutex
+	scenario SimulatorScenario
+	loop     time.Duration
+	rng      *rand.Rand
+	start    time.Time
+	last     time.Time
+
+	clouds      float64 // cover fraction, easing towards cloudTarget
+	cloudTarget float64
+	cloudUntil  time.Time
+	rainUntil   time.Time
+	wetUntil    time.Time
+	gust        float64
+	gustUntil   time.Time
+
+	// Conditions forced through the API, with when they end (zero for never)
+	forced map[string]time.Time
+
+	line []byte // last data generated, repeated while stale
+}
+
+var simulators = make(map[string]*simulator)
+
+// loadSimulator reads the source's scenario file, if any, and creates its
+// simulator
+func loadSimulator(src *SourceConfig) error {
+	scenario := defaultSimulatorScenario()
+	if src.URL != "" {
+		data, err := os.ReadFile(src.URL)
+		if err != nil {
+			return fmt.Errorf("source %s: %v", src.Name, err)
+		}
+		if err := json.Unmarshal(data, &scenario); err != nil {
+			return fmt.Errorf("source %s: invalid scenario: %v", src.Name, err)
+		}
+	}
+
+	var loop time.Duration
+	if scenario.Loop != "" {
+		var err error
+		if loop, err = time.ParseDuration(scenario.Loop); err != nil || loop <= 0 {
+			return fmt.Errorf("source %s: invalid scenario loop %q", src.Name, scenario.Loop)
+		}
+	}
+	for i, event := range scenario.Events {
+		if !containsString(simulatorConditions, event.Condition) {
+			return fmt.Errorf("source %s: unknown condition %q in scenario event %d", src.Name, event.Condition, i)
+		}
+		if _, err := time.ParseDuration(event.At); err != nil {
+			return fmt.Errorf("source %s: invalid at %q in scenario event %d", src.Name, event.At, i)
+		}
+		if d, err := time.ParseDuration(event.For); err != nil || d <= 0 {
+			return fmt.Errorf("source %s: invalid for %q in scenario event %d", src.Name, event.For, i)
+		}
+	}
+	if scenario.DropoutRate < 0 || scenario.DropoutRate >= 1 {
+		return fmt.Errorf("source %s: scenario dropoutRate must be from 0 to below 1", src.Name)
+	}
+
+	seed := scenario.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	now := time.Now()
+	simulators[src.Name] = &simulator{
+		scenario: scenario,
+		loop:     loop,
+		rng:      rand.New(rand.NewSource(seed)),
+		start:    now,
+		last:     now,
+		forced:   make(map[string]time.Time),
+	}
+	return nil
+}
+
+// active reports whether a condition is forced, by the API or a scenario
+// event, at now. Callers hold s.mu.
+func (s *simulator) active(condition string, now time.Time) bool {
+	if until, ok := s.forced[condition]; ok {
+		if until.IsZero() || now.Before(until) {
+			return true
+		}
+		delete(s.forced, condition)
+	}
+
+	elapsed := now.Sub(s.start)
+	if s.loop > 0 {
+		elapsed %= s.loop
+	}
+	for _, event := range s.scenario.Events {
+		at, _ := time.ParseDuration(event.At)
+		length, _ := time.ParseDuration(event.For)
+		if event.Condition == condition && elapsed >= at && elapsed < at+length {
+			return true
+		}
+	}
+	return false
+}
+
+// happens reports whether an event occurring rate times per hour on average
+// starts within the last hours
+func (s *simulator) happens(rate, hours float64) bool {
+	return rate > 0 && s.rng.Float64() < 1-math.Exp(-rate*hours)
+}
+
+// between returns a random duration from min to max
+func (s *simulator) between(min, max time.Duration) time.Duration {
+	return min + time.Duration(s.rng.Int63n(int64(max-min)))
+}
+
+// read returns the next reading as a Boltwood II data line, so that it goes
+// through the same parsing and fusion as a real Boltwood source
+func (s *simulator) read(now time.Time) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active("dropout", now) || s.rng.Float64() < s.scenario.DropoutRate {
+		return nil, errors.New("simulated sensor dropout")
+	}
+	if s.active("stale", now) && s.line != nil {
+		return s.line, nil
+	}
+
+	s.step(now)
+	s.line = []byte(formatBoltwoodLine(s.weather(now), 0) + "\r\n")
+	return s.line, nil
+}
+
+// step advances the random cloud passages, showers and gusts to now
+func (s *simulator) step(now time.Time) {
+	hours := now.Sub(s.last).Hours()
+	s.last = now
+	sc := s.scenario
+
+	if now.After(s.rainUntil) && s.happens(sc.RainPerDay/24, hours) {
+		s.rainUntil = now.Add(s.between(5*time.Minute, 30*time.Minute))
+	}
+	if now.After(s.cloudUntil) {
+		s.cloudTarget = 0
+		if s.happens(sc.CloudsPerHour, hours) {
+			s.cloudTarget = 0.5 + 0.5*s.rng.Float64()
+			s.cloudUntil = now.Add(s.between(10*time.Minute, 40*time.Minute))
+		}
+	}
+	if now.After(s.gustUntil) {
+		s.gust = 0
+		if s.happens(sc.GustsPerHour, hours) {
+			s.gust = sc.GustSpeed * (0.3 + 0.7*s.rng.Float64())
+			s.gustUntil = now.Add(s.between(3*time.Second, 20*time.Second))
+		}
+	}
+
+	raining := (s.active("rain", now) || now.Before(s.rainUntil)) && !s.active("clear", now)
+	target := s.cloudTarget
+	switch {
+	case s.active("clear", now):
+		target = 0
+	case raining, s.active("cloudy", now):
+		target = 1
+	}
+	if s.active("clear", now) || s.active("cloudy", now) {
+		// Forced skies take effect at once
+		s.clouds = target
+	} else {
+		// Clouds drift in and out over a few minutes
+		s.clouds += (target - s.clouds) * (1 - math.Exp(-hours*60/5))
+	}
+	if raining {
+		s.wetUntil = now.Add(20 * time.Minute)
+	}
+}
+
+// weather models the sensor values at now from the simulator state
+func (s *simulator) weather(now time.Time) WeatherData {
+	sc := s.scenario
+	loc, err := time.LoadLocation(config.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+	hour := float64(local.Hour()) + float64(local.Minute())/60
+
+	// Warmest mid-afternoon, coldest before dawn
+	ambient := sc.Temperature + sc.TemperatureRange/2*math.Cos(2*math.Pi*(hour-15)/24) + 0.1*s.rng.NormFloat64()
+	raining := (s.active("rain", now) || now.Before(s.rainUntil)) && !s.active("clear", now)
+	dewPoint := math.Min(sc.DewPoint, ambient-0.5)
+	if raining {
+		dewPoint = ambient - 0.5
+	}
+	humidity := 100 * math.Exp(magnusA*dewPoint/(magnusB+dewPoint)-magnusA*ambient/(magnusB+ambient))
+
+	wind := math.Max(sc.WindSpeed*(0.7+0.6*s.rng.Float64())+s.gust, 0)
+	if s.active("windy", now) {
+		wind = 12 + 4*s.rng.Float64()
+	}
+
+	// A clear sky reads about 28 °C below ambient, overcast close to it
+	sky := ambient - 28*(1-s.clouds) - 2 + 0.3*s.rng.NormFloat64()
+
+	data := WeatherData{
+		Date:               now.UTC(),
+		SkyTemperature:     sky,
+		AmbientTemperature: ambient,
+		SensorTemperature:  ambient + 0.5,
+		WindSpeed:          wind,
+		Humidity:           math.Round(humidity),
+		DewPoint:           dewPoint,
+		CloudCondition:     parseCloudCondition(3),
+		WindCondition:      parseWindCondition(1),
+		RainCondition:      parseRainCondition(1),
+		AlertStatus:        parseAlertStatus(0),
+	}
+	if ambient-dewPoint < dewRiskModerate {
+		data.DewHeaterPercentage = 50
+	}
+
+	switch delta := sky - ambient; {
+	case delta < -20:
+		data.CloudCondition = parseCloudCondition(1)
+	case delta < -8:
+		data.CloudCondition = parseCloudCondition(2)
+	}
+	switch {
+	case wind >= 10:
+		data.WindCondition = parseWindCondition(3)
+	case wind >= 5:
+		data.WindCondition = parseWindCondition(2)
+	}
+	switch {
+	case raining:
+		data.RainFlag, data.WetFlag = 1, 1
+		data.RainCondition = parseRainCondition(3)
+	case now.Before(s.wetUntil):
+		data.WetFlag = 1
+		data.RainCondition = parseRainCondition(2)
+	}
+	if raining || data.WindCondition == parseWindCondition(3) {
+		data.RoofCloseFlag = 1
+		data.AlertStatus = parseAlertStatus(1)
+	}
+
+	if siteConfigured() {
+		data.DarknessCondition = parseDarknessCondition(daylightCode(sunAltitude(now)))
+	} else if hour >= 6 && hour < 18 {
+		data.DarknessCondition = parseDarknessCondition(3)
+	} else {
+		data.DarknessCondition = parseDarknessCondition(1)
+	}
+	return data
+}
+
+// simulatorState is a simulator's forced and scripted conditions as reported
+// by the API
+type simulatorState struct {
+	Source     string                `json:"source"`
+	Active     []string              `json:"active"`
+	Forced     map[string]*time.Time `json:"forced"` // null until cleared
+	CloudCover float64               `json:"cloudCover"`
+}
+
+func (s *simulator) state(name string, now time.Time) simulatorState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := simulatorState{Source: name, Active: []string{}, Forced: make(map[string]*time.Time)}
+	for _, condition := range simulatorConditions {
+		if s.active(condition, now) {
+			state.Active = append(state.Active, condition)
+		}
+	}
+	for condition, until := range s.forced {
+		if until.IsZero() {
+			state.Forced[condition] = nil
+		} else {
+			until := until
+			state.Forced[condition] = &until
+		}
+	}
+	state.CloudCover = math.Round(s.clouds * 100)
+	return state
+}
+
+// force sets a condition until cleared, or for d when positive. Clear and
+// cloudy skies replace each other.
+func (s *simulator) force(condition string, d time.Duration, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch condition {
+	case "clear":
+		delete(s.forced, "cloudy")
+		delete(s.forced, "rain")
+	case "cloudy":
+		delete(s.forced, "clear")
+	case "rain":
+		delete(s.forced, "clear")
+	}
+	until := time.Time{}
+	if d > 0 {
+		until = now.Add(d)
+	}
+	s.forced[condition] = until
+}
+
+// release clears a forced condition, or all of them when condition is empty
+func (s *simulator) release(condition string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if condition == "" {
+		s.forced = make(map[string]time.Time)
+		return
+	}
+	delete(s.forced, condition)
+}
+
+// handleSimulatorAPI reports the simulator sources on GET, forces a condition
+// on POST with a JSON body such as {"condition": "rain", "for": "10m"}, and
+// clears forced conditions on DELETE. The source parameter (or body field)
+// may be left out when there is only one simulator.
+func handleSimulatorAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fail := func(status int, format string, args ...interface{}) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf(format, args...)})
+	}
+	now := time.Now()
+
+	var request struct {
+		Source    string `json:"source"`
+		Condition string `json:"condition"`
+		For       string `json:"for"`
+	}
+	request.Source = r.URL.Query().Get("source")
+	request.Condition = r.URL.Query().Get("condition")
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			fail(http.StatusBadRequest, "invalid request body: %v", err)
+			return
+		}
+	}
+
+	if r.Method == http.MethodGet && request.Source == "" {
+		names := make([]string, 0, len(simulators))
+		for name := range simulators {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		states := make([]simulatorState, 0, len(names))
+		for _, name := range names {
+			states = append(states, simulators[name].state(name, now))
+		}
+		json.NewEncoder(w).Encode(states)
+		return
+	}
+
+	if request.Source == "" && len(simulators) == 1 {
+		for name := range simulators {
+			request.Source = name
+		}
+	}
+	sim, ok := simulators[request.Source]
+	if !ok {
+		fail(http.StatusNotFound, "unknown simulator source %q", request.Source)
+		return
+	}
+	if request.Condition != "" && !containsString(simulatorConditions, request.Condition) {
+		fail(http.StatusBadRequest, "unknown condition %q", request.Condition)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		if request.Condition == "" {
+			fail(http.StatusBadRequest, "no condition given")
+			return
+		}
+		var d time.Duration
+		if request.For != "" {
+			var err error
+			if d, err = time.ParseDuration(request.For); err != nil || d <= 0 {
+				fail(http.StatusBadRequest, "invalid for %q", request.For)
+				return
+			}
+		}
+		sim.force(request.Condition, d, now)
+	case http.MethodDelete:
+		sim.release(request.Condition)
+	}
+
+	// Poll at once so that the change shows without waiting for the interval
+	if r.Method != http.MethodGet {
+		refreshWeatherData(r.Context())
+	}
+	json.NewEncoder(w).Encode(sim.state(request.Source, time.Now()))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadTestSimulator loads a scenario as the source "sim"
+func loadTestSimulator(t *testing.T, scenario string) (*simulator, error) {
+	t.Helper()
+	config = Config{}
+	src := &SourceConfig{Name: "sim", Type: "simulator"}
+	if scenario != "" {
+		src.URL = filepath.Join(t.TempDir(), "scenario.json")
+		if err := os.WriteFile(src.URL, []byte(scenario), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { delete(simulators, "sim") })
+	err := loadSimulator(src)
+	return simulators["sim"], err
+}
+
+func TestLoadSimulator(t *testing.T) {
+	s, err := loadTestSimulator(t, `{"seed": 1, "temperature": -5, "rainPerDay": 0}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The file's values replace the defaults, which fill in the rest
+	if s.scenario.Temperature != -5 || s.scenario.RainPerDay != 0 || s.scenario.WindSpeed != defaultSimulatorScenario().WindSpeed {
+		t.Errorf("scenario %+v", s.scenario)
+	}
+
+	invalid := []struct {
+		scenario string
+		want     string
+	}{
+		{`{"loop": "soon"}`, "invalid scenario loop"},
+		{`{"loop": "-1h"}`, "invalid scenario loop"},
+		{`{"events": [{"at": "1m", "for": "1m", "condition": "snow"}]}`, `unknown condition "snow"`},
+		{`{"events": [{"at": "later", "for": "1m", "condition": "rain"}]}`, "invalid at"},
+		{`{"events": [{"at": "1m", "for": "0s", "condition": "rain"}]}`, "invalid for"},
+		{`{"dropoutRate": 1}`, "dropoutRate"},
+		{`{"temperature": "warm"}`, "invalid scenario"},
+	}
+	for _, tt := range invalid {
+		if _, err := loadTestSimulator(t, tt.scenario); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("scenario %s: error %v, want %q", tt.scenario, err, tt.want)
+		}
+	}
+
+	if err := loadSimulator(&SourceConfig{Name: "sim", URL: filepath.Join(t.TempDir(), "missing.json")}); err == nil {
+		t.Error("missing scenario file accepted")
+	}
+}
+
+func TestSimulatorScenarioEvents(t *testing.T) {
+	s, err := loadTestSimulator(t, `{
+		"seed": 1, "cloudsPerHour": 0, "rainPerDay": 0, "gustsPerHour": 0, "dropoutRate": 0,
+		"loop": "1h",
+		"events": [
+			{"at": "10m", "for": "5m", "condition": "rain"},
+			{"at": "20m", "for": "5m", "condition": "stale"},
+			{"at": "30m", "for": "5m", "condition": "dropout"},
+			{"at": "40m", "for": "5m", "condition": "windy"}
+		]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(elapsed time.Duration) (WeatherData, []byte, error) {
+		t.Helper()
+		line, err := s.read(s.start.Add(elapsed))
+		if err != nil {
+			return WeatherData{}, nil, err
+		}
+		data, err := parseBoltwoodData(line)
+		if err != nil {
+			t.Fatalf("simulated line %q: %v", line, err)
+		}
+		return data, line, nil
+	}
+
+	if data, _, _ := read(5 * time.Minute); data.RainFlag != 0 || data.CloudCondition != "Clear" || data.WindCondition != "Calm" {
+		t.Errorf("quiet start: rain %d, %s, %s", data.RainFlag, data.CloudCondition, data.WindCondition)
+	}
+	if data, _, _ := read(12 * time.Minute); data.RainFlag != 1 || data.RoofCloseFlag != 1 {
+		t.Errorf("rain event: rain flag %d, roof close %d", data.RainFlag, data.RoofCloseFlag)
+	}
+	// Wet for a while after the rain stops
+	if data, _, _ := read(16 * time.Minute); data.RainFlag != 0 || data.WetFlag != 1 {
+		t.Errorf("after the rain: rain flag %d, wet flag %d", data.RainFlag, data.WetFlag)
+	}
+
+	_, before, _ := read(19 * time.Minute)
+	if _, stale, _ := read(22 * time.Minute); string(stale) != string(before) {
+		t.Errorf("stale event gave a new line %q after %q", stale, before)
+	}
+	if _, _, err := read(32 * time.Minute); err == nil {
+		t.Error("no error during the dropout event")
+	}
+	if data, _, _ := read(42 * time.Minute); data.WindCondition != "Very Windy" {
+		t.Errorf("windy event: %s", data.WindCondition)
+	}
+
+	// The scenario repeats every hour
+	if data, _, _ := read(time.Hour + 12*time.Minute); data.RainFlag != 1 {
+		t.Error("rain event not repeated by the loop")
+	}
+}
+
+func TestSimulatorForce(t *testing.T) {
+	s, err := loadTestSimulator(t, `{"seed": 1, "cloudsPerHour": 0, "rainPerDay": 0, "dropoutRate": 0}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := s.start.Add(time.Minute)
+	cloud := func() string {
+		line, err := s.read(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := parseBoltwoodData(line)
+		return data.CloudCondition
+	}
+
+	s.force("cloudy", 0, now)
+	if got := cloud(); got != "Very Cloudy" {
+		t.Errorf("forced cloudy: %s", got)
+	}
+	s.force("clear", 10*time.Minute, now)
+	if got := cloud(); got != "Clear" {
+		t.Errorf("forced clear: %s", got)
+	}
+	if state := s.state("sim", now); len(state.Active) != 1 || state.Active[0] != "clear" || state.Forced["clear"] == nil {
+		t.Errorf("state %+v", state)
+	}
+
+	// Forcing for a while ends by itself
+	now = now.Add(11 * time.Minute)
+	if state := s.state("sim", now); len(state.Active) != 0 {
+		t.Errorf("still active after the forced time: %v", state.Active)
+	}
+
+	s.force("rain", 0, now)
+	s.release("")
+	if state := s.state("sim", now); len(state.Active) != 0 || len(state.Forced) != 0 {
+		t.Errorf("after release: %+v", state)
+	}
+}
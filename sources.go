@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SourceConfig describes one weather data source feeding the device
+type SourceConfig struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"` // boltwood (default), json, sqm or simulator
+	URL         string `json:"url"`  // File path or http(s) URL; host:port for sqm; optional scenario file for simulator
+	Description string `json:"description"`
+}
+
+// sourceReading is the latest data parsed from a single source
+type sourceReading struct {
+	Data     WeatherData
+	Fields   []string // Field keys the source provides
+	Received time.Time
+
+	// Fields whose rejected value was replaced by the filter's last accepted
+	// one, dated by the reading it was accepted from
+	Held map[string]time.Time
+}
+
+var (
+	sourceReadings = make(map[string]sourceReading)
+	sourceMutex    sync.Mutex
+)
+
+// Fields supplied by a Boltwood II one-line data file
+var boltwoodFields = []string{
+	"skyTemperature", "ambientTemperature", "sensorTemperature", "windSpeed",
+	"humidity", "dewPoint", "dewHeaterPercentage", "rainFlag", "wetFlag",
+	"roofCloseFlag", "cloudCondition", "windCondition", "rainCondition",
+	"darknessCondition", "alertStatus",
+}
+
+// WeatherData fields that describe the sample rather than a measurement
+var weatherMetadataFields = map[string]bool{
+	"date":             true,
+	"temperatureScale": true,
+	"windSpeedScale":   true,
+	"sensors":          true,
+	"unsafeReasons":    true,
+	"raw":              true,
+	"rejected":         true,
+}
+
+// WeatherData fields calculated after fusion rather than read from a source,
+// with the source fields each is calculated from
+var derivedWeatherFields = map[string][]string{
+	"safe":               nil,
+	"cloudCover":         {"skyTemperature", "ambientTemperature"},
+	"calculatedDewPoint": {"ambientTemperature", "humidity"},
+	"absoluteHumidity":   {"ambientTemperature", "humidity"},
+	"dewPointDepression": {"ambientTemperature", "dewPoint"},
+	"dewRisk":            {"ambientTemperature", "dewPoint"},
+	"dewPointMismatch":   {"ambientTemperature", "humidity", "dewPoint"},
+	"windChill":          {"ambientTemperature", "windSpeed"},
+	"sunAltitude":        nil,
+	"moonAltitude":       nil,
+	"moonIllumination":   nil,
+	"daylightCondition":  nil,
+
+	"skyAmbientDeltaTrend":    {"skyTemperature", "ambientTemperature"},
+	"humidityTrend":           {"humidity"},
+	"dewPointDepressionTrend": {"ambientTemperature", "dewPoint"},
+	"timeToDew":               {"ambientTemperature", "dewPoint"},
+	"trend":                   {"skyTemperature", "ambientTemperature"},
+}
+
+// Source fields calculated from others when no source supplies them
+var calculatedSourceFields = map[string][]string{
+	"dewPoint": {"ambientTemperature", "humidity"},
+}
+
+// weatherFieldIndex maps the JSON key of every fusable WeatherData field to
+// its struct field index. weatherFieldKeys lists the keys in struct order.
+var weatherFieldIndex, weatherFieldKeys = buildWeatherFieldIndex()
+
+func buildWeatherFieldIndex() (map[string]int, []string) {
+	index := make(map[string]int)
+	var keys []string
+	t := reflect.TypeOf(WeatherData{})
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if key == "" || key == "-" || weatherMetadataFields[key] {
+			continue
+		}
+		index[key] = i
+		keys = append(keys, key)
+	}
+	return index, keys
+}
+
+func isWeatherField(key string) bool {
+	_, ok := weatherFieldIndex[key]
+	return ok
+}
+
+// isSourceField reports whether a field is one sources supply
+func isSourceField(key string) bool {
+	_, derived := derivedWeatherFields[key]
+	return isWeatherField(key) && !derived
+}
+
+// isRuleField reports whether a field can be used in safety rules: any field
+// known before the verdict itself is derived
+func isRuleField(key string) bool {
+	return isWeatherField(key) && key != "safe"
+}
+
+// copyWeatherField copies the field named by key from src to dst
+func copyWeatherField(dst, src *WeatherData, key string) {
+	i := weatherFieldIndex[key]
+	reflect.ValueOf(dst).Elem().Field(i).Set(reflect.ValueOf(src).Elem().Field(i))
+}
+
+// weatherFieldValue returns a numeric field as a float64. It reports false for
+// unknown and non-numeric (condition) fields.
+func weatherFieldValue(data *WeatherData, key string) (float64, bool) {
+	i, ok := weatherFieldIndex[key]
+	if !ok {
+		return 0, false
+	}
+	field := reflect.ValueOf(data).Elem().Field(i)
+	switch field.Kind() {
+	case reflect.Float64:
+		return field.Float(), true
+	case reflect.Int:
+		return float64(field.Int()), true
+	case reflect.Bool:
+		if field.Bool() {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// setWeatherFieldValue sets a numeric field from a float64
+func setWeatherFieldValue(data *WeatherData, key string, value float64) {
+	field := reflect.ValueOf(data).Elem().Field(weatherFieldIndex[key])
+	switch field.Kind() {
+	case reflect.Float64:
+		field.SetFloat(value)
+	case reflect.Int:
+		field.SetInt(int64(math.Round(value)))
+	}
+}
+
+// weatherFieldString returns any field formatted as a string
+func weatherFieldString(data *WeatherData, key string) (string, bool) {
+	i, ok := weatherFieldIndex[key]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprint(reflect.ValueOf(data).Elem().Field(i).Interface()), true
+}
+
+// numericWeatherFields lists the keys of all numeric fields in struct order
+func numericWeatherFields() []string {
+	var keys []string
+	var data WeatherData
+	for _, key := range weatherFieldKeys {
+		if _, ok := weatherFieldValue(&data, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func validateSources() error {
+	if len(config.Sources) == 0 {
+		if config.BoltwoodSource == "" {
+			return fmt.Errorf("BoltwoodSource is not specified in the config file")
+		}
+		config.Sources = []SourceConfig{{Name: "boltwood", Type: "boltwood", URL: config.BoltwoodSource}}
+	}
+
+	names := make(map[string]bool)
+	for i := range config.Sources {
+		src := &config.Sources[i]
+		if src.Name == "" {
+			return fmt.Errorf("source %d has no name", i)
+		}
+		if names[src.Name] {
+			return fmt.Errorf("duplicate source name %q", src.Name)
+		}
+		names[src.Name] = true
+
+		if src.Type == "" {
+			src.Type = "boltwood"
+		}
+		switch src.Type {
+		case "boltwood", "json", "sqm":
+		case "simulator":
+			if err := loadSimulator(src); err != nil {
+				return err
+			}
+			continue
+		default:
+			return fmt.Errorf("source %s has unknown type %q", src.Name, src.Type)
+		}
+		if src.URL == "" {
+			return fmt.Errorf("source %s has no url", src.Name)
+		}
+	}
+
+	for key, priority := range config.FieldPriority {
+		if !isSourceField(key) {
+			return fmt.Errorf("unknown field %q in FieldPriority", key)
+		}
+		for _, name := range priority {
+			if !names[name] {
+				return fmt.Errorf("unknown source %q in FieldPriority for %s", name, key)
+			}
+		}
+	}
+
+	// Readings older than SourceMaxAge give way to lower priority sources
+	if config.SourceMaxAge == "" {
+		interval, _ := time.ParseDuration(config.PollingInterval)
+		config.SourceMaxAge = (3 * interval).String()
+	} else {
+		duration, err := time.ParseDuration(config.SourceMaxAge)
+		if err != nil {
+			return fmt.Errorf("invalid SourceMaxAge in config file: %v", err)
+		}
+		config.SourceMaxAge = duration.String()
+	}
+
+	return nil
+}
+
+// sourceTypeFields lists the fields a source type always supplies. JSON
+// sources supply whatever keys they send.
+func sourceTypeFields(sourceType string) []string {
+	switch sourceType {
+	case "boltwood", "simulator":
+		return boltwoodFields
+	case "sqm":
+		return []string{"skyQuality"}
+	default:
+		return nil
+	}
+}
+
+func readSourceData(src *SourceConfig) ([]byte, error) {
+	switch src.Type {
+	case "sqm":
+		return readFromSQM(src.URL)
+	case "simulator":
+		return simulators[src.Name].read(time.Now())
+	}
+	return readBoltwoodData(src.URL)
+}
+
+// readFromSQM sends a reading request to a Unihedron SQM-LE and returns the reply
+func readFromSQM(address string) ([]byte, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "10001")
+	}
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte("rx")); err != nil {
+		return nil, err
+	}
+	return bufio.NewReader(conn).ReadBytes('\n')
+}
+
+func parseSourceData(src *SourceConfig, data []byte) (sourceReading, error) {
+	switch src.Type {
+	case "json":
+		return parseJSONSourceData(data)
+	case "sqm":
+		return parseSQMData(data)
+	default:
+		// Boltwood, and the simulator which writes the same format
+		weather, err := parseBoltwoodData(data)
+		return sourceReading{Data: weather, Fields: sourceTypeFields("boltwood")}, err
+	}
+}
+
+// parseJSONSourceData reads a JSON object whose keys are WeatherData field
+// names, in the driver's standard units. Unknown keys are ignored.
+func parseJSONSourceData(data []byte) (sourceReading, error) {
+	var reading sourceReading
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return reading, fmt.Errorf("invalid JSON data: %v", err)
+	}
+
+	weather := reflect.ValueOf(&reading.Data).Elem()
+	for key, raw := range values {
+		if !isSourceField(key) {
+			continue
+		}
+		field := weather.Field(weatherFieldIndex[key])
+		if err := json.Unmarshal(raw, field.Addr().Interface()); err != nil {
+			return reading, fmt.Errorf("invalid value for %s: %v", key, err)
+		}
+		reading.Fields = append(reading.Fields, key)
+	}
+
+	reading.Data.Date = time.Now().UTC()
+	if raw, ok := values["date"]; ok {
+		if err := json.Unmarshal(raw, &reading.Data.Date); err != nil {
+			return reading, fmt.Errorf("invalid date: %v", err)
+		}
+		reading.Data.Date = reading.Data.Date.UTC()
+	}
+	return reading, nil
+}
+
+// parseSQMData parses an SQM "rx" reply such as
+// "r, 19.29m,0000005915Hz,0000000000c,0000000.000s, 027.0C"
+func parseSQMData(data []byte) (sourceReading, error) {
+	var reading sourceReading
+	fields := strings.Split(strings.TrimSpace(string(data)), ",")
+	if len(fields) < 2 || fields[0] != "r" {
+		return reading, fmt.Errorf("invalid SQM reading: %q", data)
+	}
+	value, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(fields[1]), "m"), 64)
+	if err != nil {
+		return reading, fmt.Errorf("invalid SQM sky quality: %v", err)
+	}
+	reading.Data.SkyQuality = value
+	reading.Data.Date = time.Now().UTC()
+	reading.Fields = sourceTypeFields("sqm")
+	return reading, nil
+}
+
+// fuseWeatherData builds the device's weather data from the latest reading of
+// every source. Each field comes from the first source in its FieldPriority
+// list (or config order) with a reading younger than SourceMaxAge, falling
+// back to the first stale reading if none is fresh. Callers hold sourceMutex.
+func fuseWeatherData(now time.Time) WeatherData {
+	maxAge, _ := time.ParseDuration(config.SourceMaxAge)
+
+	fused := WeatherData{
+		TemperatureScale: "C",
+		WindSpeedScale:   "m/s",
+		Sensors:          make(map[string]SensorInfo),
+	}
+
+	for _, key := range weatherFieldKeys {
+		if _, derived := derivedWeatherFields[key]; derived {
+			continue
+		}
+		name, reading, ok := selectFieldSource(key, now, maxAge)
+		if !ok {
+			continue
+		}
+		copyWeatherField(&fused, &reading.Data, key)
+		updated := reading.Data.Date
+		if accepted, held := reading.Held[key]; held {
+			updated = accepted
+		}
+		fused.Sensors[key] = SensorInfo{Source: name, Updated: updated}
+		if raw, ok := reading.Data.Raw[key]; ok {
+			if fused.Raw == nil {
+				fused.Raw = make(map[string]float64)
+			}
+			fused.Raw[key] = raw
+		}
+		if containsString(reading.Data.Rejected, key) {
+			fused.Rejected = append(fused.Rejected, key)
+		}
+		if reading.Data.Date.After(fused.Date) {
+			fused.Date = reading.Data.Date
+		}
+	}
+
+	return fused
+}
+
+func selectFieldSource(key string, now time.Time, maxAge time.Duration) (string, sourceReading, bool) {
+	candidates := config.FieldPriority[key]
+	if len(candidates) == 0 {
+		for _, src := range config.Sources {
+			candidates = append(candidates, src.Name)
+		}
+	}
+
+	fallback := ""
+	for _, name := range candidates {
+		reading, ok := sourceReadings[name]
+		if !ok || !containsString(reading.Fields, key) {
+			continue
+		}
+		if now.Sub(reading.Received) <= maxAge {
+			return name, reading, true
+		}
+		if fallback == "" {
+			fallback = name
+		}
+	}
+
+	if fallback == "" {
+		return "", sourceReading{}, false
+	}
+	return fallback, sourceReadings[fallback], true
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
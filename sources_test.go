@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFuseWeatherDataPriority(t *testing.T) {
+	now := time.Date(2024, 6, 21, 22, 0, 0, 0, time.UTC)
+	fresh, stale := now.Add(-10*time.Second), now.Add(-10*time.Minute)
+
+	reading := func(received time.Time, sky, humidity float64) sourceReading {
+		return sourceReading{
+			Data:     WeatherData{Date: received, SkyTemperature: sky, Humidity: humidity},
+			Fields:   []string{"skyTemperature", "humidity"},
+			Received: received,
+		}
+	}
+
+	tests := []struct {
+		name         string
+		priority     map[string][]string
+		a, b         sourceReading
+		wantSky      float64
+		wantSkyFrom  string
+		wantHumidity float64
+	}{
+		{
+			name:         "config order",
+			a:            reading(fresh, -20, 50),
+			b:            reading(fresh, -10, 60),
+			wantSky:      -20,
+			wantSkyFrom:  "a",
+			wantHumidity: 50,
+		},
+		{
+			name:         "field priority",
+			priority:     map[string][]string{"skyTemperature": {"b", "a"}},
+			a:            reading(fresh, -20, 50),
+			b:            reading(fresh, -10, 60),
+			wantSky:      -10,
+			wantSkyFrom:  "b",
+			wantHumidity: 50,
+		},
+		{
+			name:         "stale source gives way",
+			a:            reading(stale, -20, 50),
+			b:            reading(fresh, -10, 60),
+			wantSky:      -10,
+			wantSkyFrom:  "b",
+			wantHumidity: 60,
+		},
+		{
+			name:         "falls back to first stale reading",
+			priority:     map[string][]string{"skyTemperature": {"b", "a"}},
+			a:            reading(stale, -20, 50),
+			b:            reading(stale.Add(-time.Minute), -10, 60),
+			wantSky:      -10,
+			wantSkyFrom:  "b",
+			wantHumidity: 50,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config = Config{
+				Sources:       []SourceConfig{{Name: "a"}, {Name: "b"}},
+				FieldPriority: tt.priority,
+				SourceMaxAge:  "1m",
+			}
+			sourceReadings = map[string]sourceReading{"a": tt.a, "b": tt.b}
+
+			fused := fuseWeatherData(now)
+			if fused.SkyTemperature != tt.wantSky || fused.Sensors["skyTemperature"].Source != tt.wantSkyFrom {
+				t.Errorf("skyTemperature = %v from %q, want %v from %q",
+					fused.SkyTemperature, fused.Sensors["skyTemperature"].Source, tt.wantSky, tt.wantSkyFrom)
+			}
+			if fused.Humidity != tt.wantHumidity {
+				t.Errorf("humidity = %v, want %v", fused.Humidity, tt.wantHumidity)
+			}
+			if _, ok := fused.Sensors["windSpeed"]; ok {
+				t.Errorf("windSpeed fused although no source provides it")
+			}
+		})
+	}
+}
+
+func TestParseJSONSourceData(t *testing.T) {
+	reading, err := parseJSONSourceData([]byte(`{"skyTemperature": -18.5, "cloudCondition": "Clear", "unknown": 1, "date": "2024-06-21T22:00:00Z"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reading.Data.SkyTemperature != -18.5 || reading.Data.CloudCondition != "Clear" {
+		t.Errorf("parsed %+v", reading.Data)
+	}
+	if len(reading.Fields) != 2 {
+		t.Errorf("fields = %v, want skyTemperature and cloudCondition", reading.Fields)
+	}
+	if want := time.Date(2024, 6, 21, 22, 0, 0, 0, time.UTC); !reading.Data.Date.Equal(want) {
+		t.Errorf("date = %v, want %v", reading.Data.Date, want)
+	}
+
+	if _, err := parseJSONSourceData([]byte(`{"humidity": "wet"}`)); err == nil {
+		t.Error("expected an error for a non-numeric humidity")
+	}
+}
@@ -0,0 +1,286 @@
+// Time-series charts of the weather history, drawn on canvas with no
+// external libraries. Colours come from CSS custom properties so the charts
+// follow the page theme.
+(function () {
+    'use strict';
+
+    const ranges = [
+        { label: '1 hour', seconds: 3600 },
+        { label: '3 hours', seconds: 3 * 3600 },
+        { label: '6 hours', seconds: 6 * 3600 },
+        { label: '12 hours', seconds: 12 * 3600 },
+        { label: '24 hours', seconds: 24 * 3600 },
+        { label: '3 days', seconds: 3 * 24 * 3600 },
+        { label: '7 days', seconds: 7 * 24 * 3600 },
+    ];
+
+    // Each chart plots one or more lines; value() maps a history point
+    // (or, for derived lines, the points of several fields) to a number
+    const charts = [
+        {
+            title: 'Sky - ambient temperature',
+            unit: 'temperature',
+            lines: [{ label: 'Sky - ambient', color: '--chart-1', derive: ['skyTemperature', 'ambientTemperature'], value: (a, b) => a.mean - b.mean }],
+        },
+        {
+            title: 'Temperature and dew point',
+            unit: 'temperature',
+            lines: [
+                { label: 'Ambient', color: '--chart-1', field: 'ambientTemperature', value: p => p.mean },
+                { label: 'Dew point', color: '--chart-2', field: 'dewPoint', value: p => p.mean },
+            ],
+        },
+        {
+            title: 'Humidity',
+            unit: '%',
+            min: 0, max: 100,
+            lines: [{ label: 'Humidity', color: '--chart-1', field: 'humidity', value: p => p.mean }],
+        },
+        {
+            title: 'Wind',
+            unit: 'wind',
+            min: 0,
+            lines: [
+                { label: 'Mean', color: '--chart-1', field: 'windSpeed', value: p => p.mean },
+                { label: 'Max', color: '--chart-2', field: 'windSpeed', value: p => p.max },
+            ],
+        },
+        {
+            title: 'Rain and wet flags',
+            unit: '',
+            min: 0, max: 1, steps: true,
+            lines: [
+                { label: 'Rain', color: '--chart-1', field: 'rainFlag', value: p => p.max ? 1 : 0 },
+                { label: 'Wet', color: '--chart-2', field: 'wetFlag', value: p => p.max ? 0.5 : 0 },
+            ],
+        },
+        {
+            title: 'Safety',
+            unit: '',
+            min: 0, max: 1, steps: true, labels: { 0: 'Unsafe', 1: 'Safe' },
+            lines: [{ label: 'Safe', color: '--chart-1', field: 'safe', value: p => p.min }],
+        },
+    ];
+
+    const fields = ['skyTemperature', 'ambientTemperature', 'dewPoint', 'humidity', 'windSpeed', 'rainFlag', 'wetFlag', 'safe'];
+
+    // Unit conversions, replaceable by the dashboard's unit selection
+    const units = {
+        temperature: { label: '°C', convert: v => v, delta: v => v },
+        wind: { label: 'm/s', convert: v => v },
+    };
+
+    let container, rangeSelect, lastHistory = null;
+
+    function cssColor(name) {
+        return getComputedStyle(document.body).getPropertyValue(name).trim() || '#888';
+    }
+
+    function buildCharts() {
+        rangeSelect = container.querySelector('select.chart-range');
+        if (!rangeSelect) {
+            const controls = document.createElement('div');
+            controls.className = 'chart-controls';
+            controls.innerHTML = '<label>Range <select class="chart-range"></select></label>';
+            container.appendChild(controls);
+            rangeSelect = controls.querySelector('select');
+        }
+        ranges.forEach((range, i) => {
+            const option = document.createElement('option');
+            option.value = range.seconds;
+            option.textContent = range.label;
+            option.selected = i === 2;
+            rangeSelect.appendChild(option);
+        });
+        rangeSelect.addEventListener('change', update);
+
+        charts.forEach(chart => {
+            const figure = document.createElement('figure');
+            figure.className = 'chart';
+            figure.innerHTML = '<figcaption></figcaption><canvas></canvas>';
+            figure.querySelector('figcaption').textContent = chart.title;
+            container.appendChild(figure);
+            chart.canvas = figure.querySelector('canvas');
+        });
+    }
+
+    function update() {
+        const seconds = Number(rangeSelect.value);
+        const step = Math.max(1, Math.round(seconds / 300));
+        const url = `/api/history?from=${seconds}s&step=${step}s&fields=${fields.join(',')}`;
+        fetch(url)
+            .then(response => response.json())
+            .then(history => {
+                history.stepSeconds = step;
+                lastHistory = history;
+                draw();
+            })
+            .catch(error => console.error('Error fetching weather history:', error));
+    }
+
+    function draw() {
+        if (!lastHistory) {
+            return;
+        }
+        const from = new Date(lastHistory.from).getTime();
+        const to = new Date(lastHistory.to).getTime();
+        charts.forEach(chart => drawChart(chart, lastHistory, from, to));
+    }
+
+    // linePoints turns a line definition into [time, value] pairs
+    function linePoints(line, chart, history) {
+        const convert = value => {
+            if (chart.unit === 'temperature') {
+                return line.derive ? units.temperature.delta(value) : units.temperature.convert(value);
+            }
+            if (chart.unit === 'wind') {
+                return units.wind.convert(value);
+            }
+            return value;
+        };
+
+        if (line.derive) {
+            const [a, b] = line.derive.map(key => history.series[key] || []);
+            const byTime = new Map(b.map(p => [p.time, p]));
+            return a.filter(p => byTime.has(p.time))
+                .map(p => [new Date(p.time).getTime(), convert(line.value(p, byTime.get(p.time)))]);
+        }
+        return (history.series[line.field] || [])
+            .map(p => [new Date(p.time).getTime(), convert(line.value(p))]);
+    }
+
+    function drawChart(chart, history, from, to) {
+        const canvas = chart.canvas;
+        const ratio = window.devicePixelRatio || 1;
+        const width = canvas.clientWidth, height = canvas.clientHeight;
+        canvas.width = width * ratio;
+        canvas.height = height * ratio;
+        const ctx = canvas.getContext('2d');
+        ctx.scale(ratio, ratio);
+        ctx.clearRect(0, 0, width, height);
+
+        const lines = chart.lines.map(line => ({ line, points: linePoints(line, chart, history) }));
+        const values = lines.flatMap(l => l.points.map(p => p[1]));
+
+        let min = chart.min !== undefined ? chart.min : Math.min(...values);
+        let max = chart.max !== undefined ? chart.max : Math.max(...values);
+        if (!isFinite(min) || !isFinite(max)) {
+            min = 0;
+            max = 1;
+        }
+        if (max - min < 1e-6) {
+            min -= 1;
+            max += 1;
+        }
+
+        const left = 48, right = 8, top = 8, bottom = 22;
+        const plotWidth = width - left - right, plotHeight = height - top - bottom;
+        const x = t => left + (t - from) / (to - from) * plotWidth;
+        const y = v => top + (1 - (v - min) / (max - min)) * plotHeight;
+
+        // Grid and axis labels
+        ctx.strokeStyle = cssColor('--chart-grid');
+        ctx.fillStyle = cssColor('--chart-text');
+        ctx.font = '11px sans-serif';
+        ctx.lineWidth = 1;
+        ctx.textAlign = 'right';
+        ctx.textBaseline = 'middle';
+        const ticks = chart.labels ? Object.keys(chart.labels).map(Number) : niceTicks(min, max, 5);
+        ticks.forEach(v => {
+            ctx.beginPath();
+            ctx.moveTo(left, y(v));
+            ctx.lineTo(width - right, y(v));
+            ctx.stroke();
+            const unitLabel = chart.unit === 'temperature' ? units.temperature.label :
+                chart.unit === 'wind' ? units.wind.label : chart.unit;
+            ctx.fillText(chart.labels ? chart.labels[v] : `${+v.toFixed(2)}${unitLabel}`, left - 4, y(v));
+        });
+
+        ctx.textAlign = 'center';
+        ctx.textBaseline = 'top';
+        timeTicks(from, to, 6).forEach(t => {
+            ctx.beginPath();
+            ctx.moveTo(x(t), top);
+            ctx.lineTo(x(t), top + plotHeight);
+            ctx.stroke();
+            const date = new Date(t);
+            const label = (to - from) > 24 * 3600 * 1000 ?
+                date.toLocaleDateString([], { month: 'short', day: 'numeric' }) + ' ' + date.toLocaleTimeString([], { hour: '2-digit' }) :
+                date.toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' });
+            ctx.fillText(label, x(t), top + plotHeight + 4);
+        });
+
+        // Data lines, broken where samples are missing
+        const gap = 3 * history.stepSeconds * 1000;
+        lines.forEach(({ line, points }) => {
+            ctx.strokeStyle = cssColor(line.color);
+            ctx.lineWidth = 1.5;
+            ctx.beginPath();
+            let previous = null;
+            points.forEach(([t, v]) => {
+                if (previous === null || t - previous[0] > gap) {
+                    ctx.moveTo(x(t), y(v));
+                } else if (chart.steps) {
+                    ctx.lineTo(x(t), y(previous[1]));
+                    ctx.lineTo(x(t), y(v));
+                } else {
+                    ctx.lineTo(x(t), y(v));
+                }
+                previous = [t, v];
+            });
+            ctx.stroke();
+        });
+
+        // Legend
+        if (lines.length > 1) {
+            ctx.textAlign = 'left';
+            ctx.textBaseline = 'top';
+            let legendX = left + 6;
+            lines.forEach(({ line }) => {
+                ctx.fillStyle = cssColor(line.color);
+                ctx.fillRect(legendX, top + 4, 10, 10);
+                ctx.fillStyle = cssColor('--chart-text');
+                ctx.fillText(line.label, legendX + 14, top + 3);
+                legendX += ctx.measureText(line.label).width + 30;
+            });
+        }
+    }
+
+    function niceTicks(min, max, count) {
+        const raw = (max - min) / count;
+        const magnitude = Math.pow(10, Math.floor(Math.log10(raw)));
+        const step = [1, 2, 5, 10].map(m => m * magnitude).find(s => s >= raw);
+        const ticks = [];
+        for (let v = Math.ceil(min / step) * step; v <= max + 1e-9; v += step) {
+            ticks.push(v);
+        }
+        return ticks;
+    }
+
+    function timeTicks(from, to, count) {
+        const steps = [5, 10, 15, 30, 60, 120, 180, 360, 720, 1440].map(m => m * 60 * 1000);
+        const step = steps.find(s => (to - from) / s <= count) || steps[steps.length - 1];
+        const offset = new Date().getTimezoneOffset() * 60 * 1000;
+        const ticks = [];
+        for (let t = Math.ceil((from - offset) / step) * step + offset; t <= to; t += step) {
+            ticks.push(t);
+        }
+        return ticks;
+    }
+
+    window.WeatherCharts = {
+        init(element, refreshMilliseconds) {
+            container = element;
+            buildCharts();
+            update();
+            setInterval(update, Math.max(refreshMilliseconds, 10000));
+            window.addEventListener('resize', draw);
+        },
+        setUnits(temperature, wind) {
+            units.temperature = temperature;
+            units.wind = wind;
+            draw();
+        },
+        redraw: draw,
+    };
+})();
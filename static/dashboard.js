@@ -0,0 +1,225 @@
+// Current conditions dashboard: safety banner, readings with per-sensor age,
+// theme and unit selection. Settings are kept in localStorage.
+(function () {
+    'use strict';
+
+    const temperatureUnits = {
+        C: { label: '°C', convert: v => v, delta: v => v },
+        F: { label: '°F', convert: v => v * 9 / 5 + 32, delta: v => v * 9 / 5 },
+    };
+
+    const windUnits = {
+        'm/s': { label: 'm/s', convert: v => v },
+        'km/h': { label: 'km/h', convert: v => v * 3.6 },
+        'mph': { label: 'mph', convert: v => v / 0.44704 },
+    };
+
+    // Readings shown as tiles, in display order
+    const readings = [
+        { key: 'skyTemperature', label: 'Sky Temperature', type: 'temperature' },
+        { key: 'ambientTemperature', label: 'Ambient Temperature', type: 'temperature' },
+        { key: 'sensorTemperature', label: 'Sensor Temperature', type: 'temperature' },
+        { key: 'dewPoint', label: 'Dew Point', type: 'temperature' },
+        { key: 'dewPointDepression', label: 'Dew Point Depression', type: 'temperatureDifference' },
+        { key: 'dewRisk', label: 'Dew Risk', type: 'text' },
+        { key: 'humidity', label: 'Humidity', type: 'percent' },
+        { key: 'absoluteHumidity', label: 'Absolute Humidity', type: 'number', unit: ' g/m³' },
+        { key: 'windSpeed', label: 'Wind Speed', type: 'wind' },
+        { key: 'windChill', label: 'Wind Chill', type: 'temperature' },
+        { key: 'pressure', label: 'Pressure', type: 'number', unit: ' hPa' },
+        { key: 'skyQuality', label: 'Sky Quality', type: 'number', unit: ' mag/arcsec²' },
+        { key: 'cloudCover', label: 'Cloud Cover', type: 'percent' },
+        { key: 'trend', label: 'Trend', type: 'text' },
+        { key: 'skyAmbientDeltaTrend', label: 'Sky − Ambient Trend', type: 'temperatureRate' },
+        { key: 'humidityTrend', label: 'Humidity Trend', type: 'number', unit: ' %/h' },
+        { key: 'timeToDew', label: 'Time to Dew', type: 'number', unit: ' min' },
+        { key: 'dewHeaterPercentage', label: 'Dew Heater', type: 'percent' },
+        { key: 'cloudCondition', label: 'Clouds', type: 'text' },
+        { key: 'windCondition', label: 'Wind', type: 'text' },
+        { key: 'rainCondition', label: 'Rain', type: 'text' },
+        { key: 'darknessCondition', label: 'Darkness', type: 'text' },
+        { key: 'daylightCondition', label: 'Daylight', type: 'text' },
+        { key: 'sunAltitude', label: 'Sun Altitude', type: 'number', unit: '°' },
+        { key: 'moonAltitude', label: 'Moon Altitude', type: 'number', unit: '°' },
+        { key: 'moonIllumination', label: 'Moon Illumination', type: 'percent' },
+        { key: 'alertStatus', label: 'Alert', type: 'text' },
+        { key: 'rainFlag', label: 'Rain Flag', type: 'flag' },
+        { key: 'wetFlag', label: 'Wet Flag', type: 'flag' },
+        { key: 'roofCloseFlag', label: 'Roof Close', type: 'flag' },
+        { key: 'dewPointMismatch', label: 'Dew Point Mismatch', type: 'flag' },
+    ];
+
+    let options, latest = null;
+
+    function setting(name, fallback) {
+        return localStorage.getItem(name) || fallback;
+    }
+
+    function formatValue(reading, value) {
+        switch (reading.type) {
+            case 'temperature': {
+                const unit = temperatureUnits[setting('temperatureUnit', 'C')];
+                return `${unit.convert(value).toFixed(1)}${unit.label}`;
+            }
+            case 'temperatureDifference': {
+                const unit = temperatureUnits[setting('temperatureUnit', 'C')];
+                return `${unit.delta(value).toFixed(1)}${unit.label}`;
+            }
+            case 'temperatureRate': {
+                const unit = temperatureUnits[setting('temperatureUnit', 'C')];
+                return `${unit.delta(value).toFixed(1)}${unit.label}/h`;
+            }
+            case 'wind': {
+                const unit = windUnits[setting('windUnit', 'm/s')];
+                return `${unit.convert(value).toFixed(1)} ${unit.label}`;
+            }
+            case 'percent':
+                return `${value.toFixed(0)}%`;
+            case 'number':
+                return `${value.toFixed(1)}${reading.unit}`;
+            case 'flag':
+                return value ? 'Set' : 'Clear';
+            default:
+                return value;
+        }
+    }
+
+    function formatAge(seconds) {
+        if (seconds < 90) {
+            return `${Math.max(0, Math.round(seconds))} s ago`;
+        }
+        if (seconds < 5400) {
+            return `${Math.round(seconds / 60)} min ago`;
+        }
+        return `${(seconds / 3600).toFixed(1)} h ago`;
+    }
+
+    function element(tag, className, text) {
+        const el = document.createElement(tag);
+        el.className = className;
+        if (text !== undefined) {
+            el.textContent = text;
+        }
+        return el;
+    }
+
+    function render() {
+        if (!latest) {
+            return;
+        }
+        const data = latest;
+        const now = Date.now();
+        const maxAge = options.maxDataAge / 1000;
+
+        const safety = document.getElementById('safety');
+        safety.className = 'safety ' + (data.safe ? 'safe' : 'unsafe');
+        safety.querySelector('.safety-state').textContent = data.safe ? 'SAFE' : 'UNSAFE';
+        safety.querySelector('.safety-reasons').textContent = (data.unsafeReasons || []).join(' · ');
+
+        const staleness = document.getElementById('staleness');
+        const dataAge = (now - new Date(data.date).getTime()) / 1000;
+        staleness.className = 'staleness' + (dataAge > maxAge ? ' stale' : '');
+        staleness.textContent = data.date && !data.date.startsWith('0001') ?
+            `Last update ${new Date(data.date).toLocaleString()} (${formatAge(dataAge)})` :
+            'No data received yet';
+
+        const container = document.getElementById('weather-data');
+        container.replaceChildren();
+        const sensors = data.sensors || {};
+        readings.forEach(reading => {
+            const sensor = sensors[reading.key];
+            if (!sensor) {
+                return;
+            }
+            const age = (now - new Date(sensor.updated).getTime()) / 1000;
+            const tile = element('div', 'reading' + (age > maxAge ? ' stale' : ''));
+            tile.appendChild(element('div', 'label', reading.label));
+            tile.appendChild(element('div', 'value', formatValue(reading, data[reading.key])));
+            tile.appendChild(element('div', 'age', `${sensor.source} · ${formatAge(age)}`));
+            container.appendChild(tile);
+        });
+    }
+
+    function update() {
+        fetch('/api/weather')
+            .then(response => response.json())
+            .then(data => {
+                latest = data;
+                render();
+            })
+            .catch(error => console.error('Error fetching weather data:', error));
+    }
+
+    // subscribe follows /api/stream, falling back to polling without EventSource
+    function subscribe() {
+        if (!window.EventSource) {
+            update();
+            setInterval(update, options.pollingInterval);
+            return;
+        }
+        const stream = new EventSource('/api/stream');
+        stream.addEventListener('weather', event => {
+            latest = JSON.parse(event.data);
+            render();
+        });
+        stream.addEventListener('safety', event => {
+            const verdict = JSON.parse(event.data);
+            if (latest) {
+                latest.safe = verdict.safe;
+                latest.unsafeReasons = verdict.reasons;
+                render();
+            }
+        });
+        stream.addEventListener('error', event => {
+            if (event.data) {
+                const failure = JSON.parse(event.data);
+                console.warn(`Source ${failure.source} failed: ${failure.error}`);
+            }
+        });
+    }
+
+    function applyTheme(theme) {
+        document.documentElement.dataset.theme = theme;
+        localStorage.setItem('theme', theme);
+        document.getElementById('theme-toggle').textContent = theme === 'night' ? 'Day mode' : 'Night mode';
+        WeatherCharts.redraw();
+    }
+
+    function applyUnits() {
+        WeatherCharts.setUnits(
+            temperatureUnits[setting('temperatureUnit', 'C')],
+            windUnits[setting('windUnit', 'm/s')]);
+        render();
+    }
+
+    window.Dashboard = {
+        init(dashboardOptions) {
+            options = dashboardOptions;
+
+            const temperatureSelect = document.getElementById('temperature-unit');
+            const windSelect = document.getElementById('wind-unit');
+            temperatureSelect.value = setting('temperatureUnit', 'C');
+            windSelect.value = setting('windUnit', 'm/s');
+            temperatureSelect.addEventListener('change', () => {
+                localStorage.setItem('temperatureUnit', temperatureSelect.value);
+                applyUnits();
+            });
+            windSelect.addEventListener('change', () => {
+                localStorage.setItem('windUnit', windSelect.value);
+                applyUnits();
+            });
+
+            document.getElementById('theme-toggle').addEventListener('click', () => {
+                applyTheme(document.documentElement.dataset.theme === 'night' ? 'day' : 'night');
+            });
+
+            WeatherCharts.init(document.getElementById('charts'), options.pollingInterval);
+            applyTheme(setting('theme', 'day'));
+            applyUnits();
+
+            subscribe();
+            // Keep the ages ticking between polls
+            setInterval(render, 1000);
+        },
+    };
+})();
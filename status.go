@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SourceHealth tracks the polling results of one source
+type SourceHealth struct {
+	LastSuccess         time.Time `json:"lastSuccess"`
+	LastError           string    `json:"lastError,omitempty"`
+	LastErrorTime       time.Time `json:"lastErrorTime"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	Polls               int       `json:"polls"`
+	Failures            int       `json:"failures"`
+	LastPollDuration    float64   `json:"lastPollSeconds"`
+
+	// Readings rejected by the outlier filters, by field
+	Rejections map[string]int `json:"rejections,omitempty"`
+}
+
+// DriverStatus is the /status report
+type DriverStatus struct {
+	Version         string                  `json:"version"`
+	Started         time.Time               `json:"started"`
+	Uptime          float64                 `json:"uptimeSeconds"`
+	DataAge         float64                 `json:"dataAgeSeconds"`
+	LastUpdate      time.Time               `json:"lastUpdate"`
+	Safety          SafetyVerdict           `json:"safety"`
+	AlpacaConnected bool                    `json:"alpacaConnected"`
+	StreamClients   int                     `json:"streamClients"`
+	Sources         map[string]SourceHealth `json:"sources"`
+	HistorySamples  int                     `json:"historySamples"`
+	EffectiveConfig interface{}             `json:"config"`
+}
+
+var (
+	startTime    = time.Now()
+	sourceHealth = make(map[string]SourceHealth)
+	healthMutex  sync.Mutex
+)
+
+// recordSourcePoll updates a source's health after polling it
+func recordSourcePoll(name string, duration time.Duration, err error) {
+	observePollDuration(name, duration)
+
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+
+	health := sourceHealth[name]
+	health.Polls++
+	health.LastPollDuration = duration.Seconds()
+	if err != nil {
+		health.LastError = redactText(err.Error())
+		health.LastErrorTime = time.Now()
+		health.ConsecutiveFailures++
+		health.Failures++
+	} else {
+		health.LastSuccess = time.Now()
+		health.ConsecutiveFailures = 0
+	}
+	sourceHealth[name] = health
+}
+
+// recordRejection counts a reading rejected by a field's outlier filter
+func recordRejection(name, key string) {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+
+	health := sourceHealth[name]
+	rejections := make(map[string]int, len(health.Rejections)+1)
+	for k, n := range health.Rejections {
+		rejections[k] = n
+	}
+	rejections[key]++
+	health.Rejections = rejections
+	sourceHealth[name] = health
+}
+
+func getSourceHealth() map[string]SourceHealth {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+
+	health := make(map[string]SourceHealth, len(config.Sources))
+	for _, src := range config.Sources {
+		health[src.Name] = sourceHealth[src.Name]
+	}
+	return health
+}
+
+func getDriverStatus() DriverStatus {
+	now := time.Now()
+	data := getWeatherData()
+
+	connMutex.Lock()
+	connected := isConnected
+	connMutex.Unlock()
+
+	status := DriverStatus{
+		Version:         driverVersion,
+		Started:         startTime,
+		Uptime:          now.Sub(startTime).Seconds(),
+		LastUpdate:      data.Date,
+		Safety:          currentSafety(),
+		AlpacaConnected: connected,
+		StreamClients:   events.subscriberCount(),
+		Sources:         getSourceHealth(),
+		HistorySamples:  history.size(),
+		EffectiveConfig: redactedConfig(),
+	}
+	if !data.Date.IsZero() {
+		status.DataAge = now.Sub(data.Date).Seconds()
+	}
+	return status
+}
+
+// Config keys whose values are never shown
+var secretKeyPattern = regexp.MustCompile(`(?i)(password|passwd|secret|token|apikey|api_key)`)
+
+// redactedConfig returns the effective configuration as generic JSON with
+// secrets, URL passwords and sensitive URL query values replaced
+func redactedConfig() interface{} {
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return nil
+	}
+	var generic interface{}
+	json.Unmarshal(raw, &generic)
+	return redactValue("", generic)
+}
+
+func redactValue(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = redactValue(k, item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(key, item)
+		}
+		return v
+	case string:
+		if v != "" && secretKeyPattern.MatchString(key) {
+			return "REDACTED"
+		}
+		return redactURL(v)
+	default:
+		return v
+	}
+}
+
+// Secret query parameters embedded in free text such as error messages
+var secretParamPattern = regexp.MustCompile(`(?i)((?:password|passwd|secret|token|apikey|api_key)[^=&\s"]*=)[^&\s"]+`)
+
+func redactText(text string) string {
+	return secretParamPattern.ReplaceAllString(text, "${1}REDACTED")
+}
+
+func redactURL(value string) string {
+	if !strings.Contains(value, "://") {
+		return value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return value
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), "REDACTED")
+	}
+	query := u.Query()
+	for k := range query {
+		if secretKeyPattern.MatchString(k) {
+			query.Set(k, "REDACTED")
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// handleStatus reports driver health as JSON, or as a page for browsers
+// (Accept: text/html) and ?format=html
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := getDriverStatus()
+
+	wantsHTML := r.URL.Query().Get("format") == "html" ||
+		(r.URL.Query().Get("format") == "" && strings.Contains(r.Header.Get("Accept"), "text/html"))
+	if !wantsHTML {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+		return
+	}
+
+	t, err := template.New("status.html").Funcs(template.FuncMap{
+		"duration": func(seconds float64) string {
+			return (time.Duration(seconds * float64(time.Second))).Round(time.Second).String()
+		},
+		"json": func(v interface{}) string {
+			out, _ := json.MarshalIndent(v, "", "  ")
+			return string(out)
+		},
+	}).ParseFS(templateFiles, "templates/status.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := t.Execute(w, status); err != nil {
+		log.Printf("Error executing template: %v", err)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyStore persists every published sample to append-only JSON-lines
+// segment files, one per UTC day. Segments older than CompactAfter are
+// rewritten keeping one sample per CompactStep, and segments older than
+// HistoryRetention, unless it is zero, are deleted.
+type historyStore struct {
+	mu      sync.Mutex
+	dir     string
+	file    *os.File
+	fileDay string
+	last    WeatherData
+}
+
+type historySegment struct {
+	Path      string
+	Day       time.Time
+	Compacted bool
+}
+
+const segmentDateFormat = "2006-01-02"
+
+var store *historyStore
+
+// initHistoryStore opens the history directory, reloads the recent window
+// into the in-memory history and starts recording new samples
+func initHistoryStore() error {
+	if config.HistoryDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(config.HistoryDir, 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %v", err)
+	}
+	store = &historyStore{dir: config.HistoryDir}
+
+	maxAge, _ := time.ParseDuration(config.HistoryDuration)
+	now := time.Now()
+	samples, err := store.query(now.Add(-maxAge), now)
+	if err != nil {
+		return fmt.Errorf("failed to load history: %v", err)
+	}
+	for _, sample := range samples {
+		history.add(sample)
+	}
+	if len(samples) > 0 {
+		store.last = samples[len(samples)-1]
+	}
+	log.Printf("Loaded %d samples from %s", len(samples), config.HistoryDir)
+
+	onWeatherUpdate(store.append)
+	go store.maintain()
+	return nil
+}
+
+// queryHistory returns samples in [from, to], reading from disk when the range
+// reaches back beyond the in-memory history
+func queryHistory(from, to time.Time) []WeatherData {
+	maxAge, _ := time.ParseDuration(config.HistoryDuration)
+	if store == nil || !from.Before(time.Now().Add(-maxAge)) {
+		return history.query(from, to)
+	}
+	samples, err := store.query(from, to)
+	if err != nil {
+		log.Printf("Error reading history: %v", err)
+	}
+	return samples
+}
+
+func (s *historyStore) append(data WeatherData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if isRepeatSample(s.last, data) {
+		return
+	}
+	s.last = data
+
+	day := data.Date.UTC().Format(segmentDateFormat)
+	if s.file == nil || s.fileDay != day {
+		if s.file != nil {
+			s.file.Close()
+		}
+		path := filepath.Join(s.dir, "weather-"+day+".jsonl")
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Printf("Error opening history segment: %v", err)
+			s.file = nil
+			return
+		}
+		s.file, s.fileDay = file, day
+	}
+
+	line, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error encoding history sample: %v", err)
+		return
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		log.Printf("Error writing history sample: %v", err)
+	}
+}
+
+// segments lists the segment files in day order
+func (s *historyStore) segments() ([]historySegment, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "weather-*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []historySegment
+	for _, path := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "weather-"), ".jsonl")
+		compacted := strings.HasSuffix(name, ".compact")
+		day, err := time.Parse(segmentDateFormat, strings.TrimSuffix(name, ".compact"))
+		if err != nil {
+			continue
+		}
+		segments = append(segments, historySegment{Path: path, Day: day, Compacted: compacted})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Day.Before(segments[j].Day) })
+	return segments, nil
+}
+
+// query reads the samples dated within [from, to] from disk
+func (s *historyStore) query(from, to time.Time) ([]WeatherData, error) {
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []WeatherData
+	for _, segment := range segments {
+		if segment.Day.Add(24*time.Hour).Before(from) || segment.Day.After(to) {
+			continue
+		}
+		samples, err := readSegment(segment.Path)
+		if err != nil {
+			return result, err
+		}
+		for _, sample := range samples {
+			if !sample.Date.Before(from) && !sample.Date.After(to) {
+				result = append(result, sample)
+			}
+		}
+	}
+
+	// A day's late samples sit in a plain segment beside its compacted one
+	// until the next compaction merges them
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Date.Before(result[j].Date) })
+	return result, nil
+}
+
+func readSegment(path string) ([]WeatherData, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var samples []WeatherData
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var sample WeatherData
+		// Skip lines truncated by a crash mid-write
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	return samples, scanner.Err()
+}
+
+// maintain applies retention and compaction once an hour
+func (s *historyStore) maintain() {
+	for {
+		if err := s.applyRetention(time.Now()); err != nil {
+			log.Printf("Error maintaining history: %v", err)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+func (s *historyStore) applyRetention(now time.Time) error {
+	retention, _ := time.ParseDuration(config.HistoryRetention)
+	compactAfter, _ := time.ParseDuration(config.CompactAfter)
+	compactStep, _ := time.ParseDuration(config.CompactStep)
+
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		end := segment.Day.Add(24 * time.Hour)
+		switch {
+		case retention > 0 && now.Sub(end) > retention:
+			log.Printf("Removing expired history segment %s", segment.Path)
+			if err := os.Remove(segment.Path); err != nil {
+				return err
+			}
+		case compactAfter > 0 && !segment.Compacted && now.Sub(end) > compactAfter:
+			if err := s.compact(segment, compactStep); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compact compacts a segment, first closing it if late samples are still
+// being appended to it
+func (s *historyStore) compact(segment historySegment, step time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file != nil && s.fileDay == segment.Day.Format(segmentDateFormat) {
+		s.file.Close()
+		s.file = nil
+	}
+	return compactSegment(segment, step)
+}
+
+// compactSegment rewrites a segment keeping the first sample of each step.
+// Samples arriving late for a day already compacted are merged into its
+// compacted segment.
+func compactSegment(segment historySegment, step time.Duration) error {
+	samples, err := readSegment(segment.Path)
+	if err != nil {
+		return err
+	}
+
+	compactPath := strings.TrimSuffix(segment.Path, ".jsonl") + ".compact.jsonl"
+	compacted, err := readSegment(compactPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	samples = append(compacted, samples...)
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Date.Before(samples[j].Date) })
+	tmp, err := os.CreateTemp(filepath.Dir(segment.Path), ".compact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	kept := 0
+	var lastBucket time.Time
+	for _, sample := range samples {
+		bucket := sample.Date.Truncate(step)
+		if kept > 0 && bucket.Equal(lastBucket) {
+			continue
+		}
+		if err := encoder.Encode(sample); err != nil {
+			tmp.Close()
+			return err
+		}
+		lastBucket = bucket
+		kept++
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), compactPath); err != nil {
+		return err
+	}
+	log.Printf("Compacted history segment %s from %d to %d samples", segment.Path, len(samples), kept)
+	return os.Remove(segment.Path)
+}
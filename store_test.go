@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompactionMergesLateSamples(t *testing.T) {
+	config = Config{HistoryRetention: "720h", CompactAfter: "24h", CompactStep: "10m"}
+	s := &historyStore{dir: t.TempDir()}
+	day := time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC)
+	now := day.Add(72 * time.Hour)
+
+	for i := 0; i < 12; i++ {
+		s.append(historySample(day.Add(time.Duration(i)*5*time.Minute), float64(i)))
+	}
+	if err := s.applyRetention(now); err != nil {
+		t.Fatal(err)
+	}
+
+	// A sample dated into the compacted day, as from a source with a lagging clock
+	s.append(historySample(day.Add(12*time.Hour), 99))
+	samples, err := s.query(day, day.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 7 {
+		t.Fatalf("query before re-compaction returned %d samples, want 7", len(samples))
+	}
+
+	if err := s.applyRetention(now); err != nil {
+		t.Fatal(err)
+	}
+	samples, err = s.query(day, day.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 7 || samples[6].Humidity != 99 {
+		t.Fatalf("after re-compaction got %d samples ending %+v, want the 6 compacted plus the late one", len(samples), samples[len(samples)-1])
+	}
+	for i := 1; i < len(samples); i++ {
+		if samples[i].Date.Before(samples[i-1].Date) {
+			t.Errorf("samples out of order at %d", i)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(s.dir, "weather-2024-06-21.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("plain segment still present")
+	}
+}
+
+func TestRetention(t *testing.T) {
+	day := time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC)
+	now := day.Add(40 * 24 * time.Hour)
+	for _, tt := range []struct {
+		retention string
+		wantKept  bool
+	}{
+		{"720h", false},
+		{"0s", true}, // kept forever
+	} {
+		config = Config{HistoryRetention: tt.retention, CompactStep: "5m"}
+		s := &historyStore{dir: t.TempDir()}
+		s.append(historySample(day.Add(time.Hour), 50))
+		s.append(historySample(now, 60))
+		if err := s.applyRetention(now); err != nil {
+			t.Fatal(err)
+		}
+		samples, err := s.query(day, now.Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kept := len(samples) == 2; kept != tt.wantKept {
+			t.Errorf("retention %s: %d samples left, want the old day kept %v", tt.retention, len(samples), tt.wantKept)
+		}
+	}
+}
+
+func TestValidateHistorySettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"defaults", Config{}, false},
+		{"keep forever", Config{HistoryRetention: "0s", CompactAfter: "0s"}, false},
+		{"negative retention", Config{HistoryRetention: "-1h"}, true},
+		{"zero compaction step", Config{CompactStep: "0s"}, true},
+	}
+	for _, tt := range tests {
+		config = tt.config
+		config.WebServerPort = 8080
+		config.PollingInterval = "30s"
+		config.BoltwoodSource = "/tmp/boltwood.txt"
+		if err := validateConfig(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateConfig() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// streamEvent is one Server-Sent Event
+type streamEvent struct {
+	ID   uint64
+	Type string // weather, safety or error
+	Data []byte
+}
+
+// eventBroker fans events out to /api/stream subscribers, keeping the most
+// recent events so reconnecting clients can resume from Last-Event-ID
+type eventBroker struct {
+	mu          sync.Mutex
+	nextID      uint64
+	recent      []streamEvent
+	subscribers map[chan streamEvent]bool
+}
+
+const (
+	streamReplaySize    = 100
+	streamBufferSize    = 16
+	streamHeartbeat     = 15 * time.Second
+	streamRetryInterval = 5 * time.Second
+)
+
+var events = &eventBroker{subscribers: make(map[chan streamEvent]bool)}
+
+// initStream publishes weather updates, safety changes and source errors
+func initStream() {
+	onWeatherUpdate(func(data WeatherData) {
+		events.publish("weather", data)
+	})
+	onSafetyChange(func(verdict SafetyVerdict) {
+		events.publish("safety", verdict)
+	})
+	onSourceError(func(source string, err error) {
+		events.publish("error", map[string]string{"source": source, "error": err.Error()})
+	})
+}
+
+func (b *eventBroker) publish(eventType string, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", eventType, err)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	event := streamEvent{ID: b.nextID, Type: eventType, Data: data}
+	b.recent = append(b.recent, event)
+	if len(b.recent) > streamReplaySize {
+		b.recent = b.recent[len(b.recent)-streamReplaySize:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// Drop subscribers that cannot keep up; they reconnect and replay
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe registers a subscriber, returning the events published after
+// lastID that are still held for replay
+func (b *eventBroker) subscribe(lastID uint64) (chan streamEvent, []streamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan streamEvent, streamBufferSize)
+	b.subscribers[ch] = true
+
+	var backlog []streamEvent
+	if lastID > 0 {
+		for _, event := range b.recent {
+			if event.ID > lastID {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+	return ch, backlog
+}
+
+func (b *eventBroker) unsubscribe(ch chan streamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[ch] {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// subscriberCount returns the number of connected stream clients
+func (b *eventBroker) subscriberCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+func writeStreamEvent(w http.ResponseWriter, event streamEvent) error {
+	if event.ID > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", event.ID); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, event.Data)
+	return err
+}
+
+// handleStreamAPI serves weather updates as Server-Sent Events. New clients
+// start with the current snapshot; reconnecting clients sending Last-Event-ID
+// receive the events they missed instead.
+func handleStreamAPI(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	lastID, _ := strconv.ParseUint(lastEventID, 10, 64)
+
+	ch, backlog := events.subscribe(lastID)
+	defer events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetryInterval.Milliseconds())
+
+	if lastID == 0 {
+		snapshot, _ := json.Marshal(getLiveWeatherData())
+		writeStreamEvent(w, streamEvent{Type: "weather", Data: snapshot})
+	}
+	for _, event := range backlog {
+		writeStreamEvent(w, event)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := writeStreamEvent(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
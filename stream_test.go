@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestBroker() *eventBroker {
+	return &eventBroker{subscribers: make(map[chan streamEvent]bool)}
+}
+
+func TestEventBrokerReplay(t *testing.T) {
+	b := newTestBroker()
+	for i := 0; i < streamReplaySize+20; i++ {
+		b.publish("weather", i)
+	}
+
+	tests := []struct {
+		name      string
+		lastID    uint64
+		wantFirst uint64
+		wantCount int
+	}{
+		{"new client", 0, 0, 0},
+		{"recent event", 115, 116, 5},
+		{"up to date", 120, 0, 0},
+		{"older than the replay buffer", 3, 21, streamReplaySize},
+	}
+	for _, tt := range tests {
+		ch, backlog := b.subscribe(tt.lastID)
+		b.unsubscribe(ch)
+		if len(backlog) != tt.wantCount {
+			t.Errorf("%s: %d events replayed, want %d", tt.name, len(backlog), tt.wantCount)
+			continue
+		}
+		if tt.wantCount > 0 && (backlog[0].ID != tt.wantFirst || backlog[len(backlog)-1].ID != 120) {
+			t.Errorf("%s: replayed events %d to %d", tt.name, backlog[0].ID, backlog[len(backlog)-1].ID)
+		}
+	}
+}
+
+func TestEventBrokerDropsSlowSubscribers(t *testing.T) {
+	b := newTestBroker()
+	slow, _ := b.subscribe(0)
+	fast, _ := b.subscribe(0)
+
+	for i := 0; i < streamBufferSize+1; i++ {
+		b.publish("weather", i)
+		<-fast
+	}
+	if b.subscriberCount() != 1 {
+		t.Fatalf("%d subscribers, want the slow one dropped", b.subscriberCount())
+	}
+
+	// The slow subscriber gets what was buffered, then a closed channel
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != streamBufferSize {
+		t.Errorf("slow subscriber received %d events, want %d", received, streamBufferSize)
+	}
+
+	// Unsubscribing after being dropped is harmless
+	b.unsubscribe(slow)
+	b.unsubscribe(fast)
+	if b.subscriberCount() != 0 {
+		t.Errorf("%d subscribers left", b.subscriberCount())
+	}
+}
+
+func TestStreamAPIResumes(t *testing.T) {
+	saved := events
+	events = newTestBroker()
+	defer func() { events = saved }()
+	events.publish("weather", map[string]int{"n": 1})
+	events.publish("safety", SafetyVerdict{Safe: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/api/stream", nil).WithContext(ctx)
+	r.Header.Set("Last-Event-ID", "1")
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		handleStreamAPI(w, r)
+		close(done)
+	}()
+
+	// Wait for the subscription before publishing a live event
+	deadline := time.Now().Add(5 * time.Second)
+	for events.subscriberCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	events.publish("error", map[string]string{"source": "roof"})
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(w.Body.String()))
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	want := []string{
+		"retry: 5000",
+		"id: 2", "event: safety", `data: {"safe":true}`,
+		"id: 3", "event: error", `data: {"source":"roof"}`,
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("stream:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+	if events.subscriberCount() != 0 {
+		t.Error("subscriber left after the client went away")
+	}
+}
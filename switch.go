@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// SwitchConfig enables the Alpaca switch/0 device. Its first switches mirror
+// the weather flags and safety verdict; Outputs follow as writable switches.
+type SwitchConfig struct {
+	Enabled bool                 `json:"enabled"`
+	Name    string               `json:"name"`
+	Outputs []SwitchOutputConfig `json:"outputs"`
+}
+
+// SwitchOutputConfig is a writable on/off output such as a dew heater relay,
+// driven by an actuator with "on" and "off" commands
+type SwitchOutputConfig struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Actuator    ActuatorConfig `json:"actuator"`
+}
+
+// weatherSwitch is a read-only switch reporting a weather state
+type weatherSwitch struct {
+	Name        string
+	Description string
+	Value       func(data WeatherData) (bool, error)
+}
+
+// weatherFlagSwitch reads a non-zero numeric field as on
+func weatherFlagSwitch(key string) func(WeatherData) (bool, error) {
+	return func(data WeatherData) (bool, error) {
+		if _, ok := data.Sensors[key]; !ok {
+			return false, newAlpacaError(errValueNotSet, "No %s data received", key)
+		}
+		value, _ := weatherFieldValue(&data, key)
+		return value != 0, nil
+	}
+}
+
+var weatherSwitches = []weatherSwitch{
+	{"Rain", "Rain detected (Boltwood rain flag)", weatherFlagSwitch("rainFlag")},
+	{"Wet", "Sensor wet (Boltwood wet flag)", weatherFlagSwitch("wetFlag")},
+	{"Roof Close", "Roof close requested by the weather station", weatherFlagSwitch("roofCloseFlag")},
+	{"Alert", "Weather alert active", func(data WeatherData) (bool, error) {
+		if _, ok := data.Sensors["alertStatus"]; !ok {
+			return false, newAlpacaError(errValueNotSet, "No alertStatus data received")
+		}
+		return data.AlertStatus == parseAlertStatus(1), nil
+	}},
+	{"Safe", "Safety verdict, on when safe to observe", func(WeatherData) (bool, error) {
+		return currentSafety().Safe, nil
+	}},
+}
+
+// switchOutput is a writable switch and its last commanded state. Actuators
+// cannot report a relay's state, so an output reads off until it is first
+// set, whatever the relay was left at.
+type switchOutput struct {
+	config   SwitchOutputConfig
+	actuator actuator
+	on       bool
+}
+
+type switchDevice struct {
+	mu        sync.Mutex
+	outputs   []*switchOutput
+	connected bool
+}
+
+var switches *switchDevice
+
+func validateSwitchConfig() error {
+	s := &config.Switch
+	if !s.Enabled {
+		return nil
+	}
+	if s.Name == "" {
+		s.Name = "Observatory Switches"
+	}
+	for i := range s.Outputs {
+		output := &s.Outputs[i]
+		if output.Name == "" {
+			return fmt.Errorf("switch output %d has no name", i)
+		}
+		if err := validateActuatorConfig("switch "+output.Name, &output.Actuator, []string{"on", "off"}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func initSwitches() {
+	if !config.Switch.Enabled {
+		return
+	}
+	switches = &switchDevice{}
+	for _, outputConfig := range config.Switch.Outputs {
+		switches.outputs = append(switches.outputs, &switchOutput{
+			config:   outputConfig,
+			actuator: newActuator(outputConfig.Actuator),
+		})
+	}
+}
+
+func (s *switchDevice) maxSwitch() int {
+	return len(weatherSwitches) + len(s.outputs)
+}
+
+// switchID parses the Id parameter, returning the output for writable
+// switches and nil for the weather switches
+func (s *switchDevice) switchID(r *http.Request) (int, *switchOutput, error) {
+	id, err := strconv.Atoi(getAlpacaParam(r, "Id"))
+	if err != nil || id < 0 || id >= s.maxSwitch() {
+		return 0, nil, newAlpacaError(errInvalidValue, "Invalid switch Id %q", getAlpacaParam(r, "Id"))
+	}
+	if id < len(weatherSwitches) {
+		return id, nil, nil
+	}
+	return id, s.outputs[id-len(weatherSwitches)], nil
+}
+
+func (s *switchDevice) get(r *http.Request) (bool, error) {
+	id, output, err := s.switchID(r)
+	if err != nil {
+		return false, err
+	}
+	if output == nil {
+		return weatherSwitches[id].Value(getWeatherData())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return output.on, nil
+}
+
+func (s *switchDevice) set(r *http.Request, on bool) error {
+	_, output, err := s.switchID(r)
+	if err != nil {
+		return err
+	}
+	if output == nil {
+		return newAlpacaError(errNotImplemented, "Weather switches are read-only")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.connected {
+		return newAlpacaError(errNotConnected, "Switch is not connected")
+	}
+	command := "off"
+	if on {
+		command = "on"
+	}
+	if err := output.actuator.command(command); err != nil {
+		return err
+	}
+	log.Printf("Switch %s turned %s by %s", output.config.Name, command, r.RemoteAddr)
+	output.on = on
+	return nil
+}
+
+// switchInfo returns a handler for a per-switch property
+func switchInfo(get func(id int, output *switchOutput) interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handleAlpacaResponse(w, r, func() (interface{}, error) {
+			id, output, err := switches.switchID(r)
+			if err != nil {
+				return nil, err
+			}
+			return get(id, output), nil
+		})
+	}
+}
+
+func handleGetSwitch(w http.ResponseWriter, r *http.Request) {
+	handleAlpacaResponse(w, r, func() (interface{}, error) {
+		return switches.get(r)
+	})
+}
+
+func handleGetSwitchValue(w http.ResponseWriter, r *http.Request) {
+	handleAlpacaResponse(w, r, func() (interface{}, error) {
+		on, err := switches.get(r)
+		if on {
+			return 1.0, err
+		}
+		return 0.0, err
+	})
+}
+
+func handleSetSwitch(w http.ResponseWriter, r *http.Request) {
+	handleAlpacaAction(w, r, func() error {
+		state, err := strconv.ParseBool(getAlpacaParam(r, "State"))
+		if err != nil {
+			return newAlpacaError(errInvalidValue, "Invalid State value")
+		}
+		return switches.set(r, state)
+	})
+}
+
+func handleSetSwitchValue(w http.ResponseWriter, r *http.Request) {
+	handleAlpacaAction(w, r, func() error {
+		value, err := strconv.ParseFloat(getAlpacaParam(r, "Value"), 64)
+		if err != nil || (value != 0 && value != 1) {
+			return newAlpacaError(errInvalidValue, "Invalid Value %q, must be 0 or 1", getAlpacaParam(r, "Value"))
+		}
+		return switches.set(r, value == 1)
+	})
+}
+
+func handleSetSwitchName(w http.ResponseWriter, r *http.Request) {
+	handleAlpacaAction(w, r, func() error {
+		if _, _, err := switches.switchID(r); err != nil {
+			return err
+		}
+		return newAlpacaError(errNotImplemented, "Switch names are set in the config file")
+	})
+}
+
+func setupSwitchRoutes(router *mux.Router) {
+	const prefix = "/api/v1/switch/0/"
+
+	router.HandleFunc(prefix+"connected", alpacaConnected(&switches.connected, &switches.mu)).Methods("GET", "PUT")
+	router.HandleFunc(prefix+"description", alpacaProperty("Weather flags, safety verdict and observatory outputs")).Methods("GET")
+	router.HandleFunc(prefix+"driverinfo", alpacaProperty("ASCOM Alpaca Boltwood II Switch Driver v"+driverVersion)).Methods("GET")
+	router.HandleFunc(prefix+"driverversion", alpacaProperty("v"+driverVersion)).Methods("GET")
+	router.HandleFunc(prefix+"interfaceversion", alpacaProperty(2)).Methods("GET")
+	router.HandleFunc(prefix+"name", alpacaProperty(config.Switch.Name)).Methods("GET")
+	router.HandleFunc(prefix+"supportedactions", alpacaProperty([]string{})).Methods("GET")
+
+	router.HandleFunc(prefix+"maxswitch", alpacaProperty(switches.maxSwitch())).Methods("GET")
+	router.HandleFunc(prefix+"canwrite", switchInfo(func(id int, output *switchOutput) interface{} {
+		return output != nil
+	})).Methods("GET")
+	router.HandleFunc(prefix+"getswitchname", switchInfo(func(id int, output *switchOutput) interface{} {
+		if output != nil {
+			return output.config.Name
+		}
+		return weatherSwitches[id].Name
+	})).Methods("GET")
+	router.HandleFunc(prefix+"getswitchdescription", switchInfo(func(id int, output *switchOutput) interface{} {
+		if output != nil {
+			return output.config.Description
+		}
+		return weatherSwitches[id].Description
+	})).Methods("GET")
+
+	// Every switch is boolean
+	router.HandleFunc(prefix+"minswitchvalue", switchInfo(func(int, *switchOutput) interface{} { return 0.0 })).Methods("GET")
+	router.HandleFunc(prefix+"maxswitchvalue", switchInfo(func(int, *switchOutput) interface{} { return 1.0 })).Methods("GET")
+	router.HandleFunc(prefix+"switchstep", switchInfo(func(int, *switchOutput) interface{} { return 1.0 })).Methods("GET")
+
+	router.HandleFunc(prefix+"getswitch", handleGetSwitch).Methods("GET")
+	router.HandleFunc(prefix+"getswitchvalue", handleGetSwitchValue).Methods("GET")
+	router.HandleFunc(prefix+"setswitch", handleSetSwitch).Methods("PUT")
+	router.HandleFunc(prefix+"setswitchvalue", handleSetSwitchValue).Methods("PUT")
+	router.HandleFunc(prefix+"setswitchname", handleSetSwitchName).Methods("PUT")
+}
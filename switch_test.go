@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSetSwitchRequiresConnection(t *testing.T) {
+	config = Config{Switch: SwitchConfig{Enabled: true, Outputs: []SwitchOutputConfig{{Name: "Dew Heater"}}}}
+	initSwitches()
+	defer func() { switches = nil }()
+	actuator := switches.outputs[0].actuator.(*simulatedActuator)
+	id := len(weatherSwitches)
+
+	setSwitch := func() int {
+		form := url.Values{"Id": {strconv.Itoa(id)}, "State": {"true"}, "ClientID": {"1"}, "ClientTransactionID": {"1"}}
+		r := httptest.NewRequest(http.MethodPut, "/api/v1/switch/0/setswitch", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handleSetSwitch(w, r)
+		var response struct{ ErrorNumber int }
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return response.ErrorNumber
+	}
+
+	if errorNumber := setSwitch(); errorNumber != errNotConnected || actuator.state != "closed" || switches.outputs[0].on {
+		t.Errorf("set while disconnected: ErrorNumber 0x%X, actuator %q", errorNumber, actuator.state)
+	}
+	switches.connected = true
+	if errorNumber := setSwitch(); errorNumber != 0 || actuator.state != "on" || !switches.outputs[0].on {
+		t.Errorf("set while connected: ErrorNumber 0x%X, actuator %q", errorNumber, actuator.state)
+	}
+}
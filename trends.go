@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Slopes beyond which the sky is taken to be clearing or deteriorating
+const (
+	trendSkyDeltaSlope = 2.0  // °C per hour of sky minus ambient temperature
+	trendHumiditySlope = 10.0 // % per hour
+	trendDewWarning    = 60.0 // minutes until dew
+)
+
+// Trend conditions, coded 1 to 3 by parseTrendCondition like the other conditions
+const (
+	trendClearing      = "Clearing"
+	trendSteady        = "Steady"
+	trendDeteriorating = "Deteriorating"
+)
+
+// ReopenConfig delays the verdict turning safe again after being unsafe
+// until the trend has stayed one of Trends (default Clearing or Steady) for
+// Delay. The trend needs sources of the sky and ambient temperatures.
+//
+// Startup counts as unsafe, so after a restart the verdict stays unsafe
+// until the trend has held for the full Delay, even if conditions were
+// safe before the restart.
+type ReopenConfig struct {
+	Delay  string   `json:"delay"`
+	Trends []string `json:"trends"`
+}
+
+func parseTrendCondition(val int) string {
+	switch val {
+	case 1:
+		return trendClearing
+	case 2:
+		return trendSteady
+	case 3:
+		return trendDeteriorating
+	default:
+		return "Unknown"
+	}
+}
+
+func validateTrendConfig() error {
+	if config.TrendWindow == "" {
+		config.TrendWindow = "30m"
+	}
+	window, err := time.ParseDuration(config.TrendWindow)
+	if err != nil || window <= 0 {
+		return fmt.Errorf("invalid TrendWindow in config file: %q", config.TrendWindow)
+	}
+	config.TrendWindow = window.String()
+
+	reopen := &config.Safety.Reopen
+	if reopen.Delay == "" {
+		return nil
+	}
+	delay, err := time.ParseDuration(reopen.Delay)
+	if err != nil || delay <= 0 {
+		return fmt.Errorf("invalid Safety.Reopen.Delay in config file: %q", reopen.Delay)
+	}
+	reopen.Delay = delay.String()
+	if !sourcesCanSupply("skyTemperature", "ambientTemperature") {
+		return fmt.Errorf("Safety.Reopen needs a source of skyTemperature and ambientTemperature for the trend")
+	}
+	if reopen.Trends == nil {
+		reopen.Trends = []string{trendClearing, trendSteady}
+	}
+	for _, trend := range reopen.Trends {
+		if trend != trendClearing && trend != trendSteady && trend != trendDeteriorating {
+			return fmt.Errorf("unknown trend %q in Safety.Reopen.Trends", trend)
+		}
+	}
+	return nil
+}
+
+// sourcesCanSupply reports whether the configured sources can supply every
+// key, counting JSON sources as able to send any field
+func sourcesCanSupply(keys ...string) bool {
+	for _, key := range keys {
+		supplied := false
+		for _, src := range config.Sources {
+			if src.Type == "json" || containsString(sourceTypeFields(src.Type), key) {
+				supplied = true
+				break
+			}
+		}
+		if !supplied {
+			return false
+		}
+	}
+	return true
+}
+
+// trendSlope fits a line through the values of the samples providing the
+// keys, returning units per hour. It reports false unless the samples span
+// at least half the window.
+func trendSlope(samples []WeatherData, window time.Duration, value func(*WeatherData) float64, keys ...string) (float64, bool) {
+	var times, values []float64
+	var first, last time.Time
+	for i := range samples {
+		if !hasSensors(samples[i], keys...) {
+			continue
+		}
+		if first.IsZero() {
+			first = samples[i].Date
+		}
+		last = samples[i].Date
+		times = append(times, samples[i].Date.Sub(samples[0].Date).Hours())
+		values = append(values, value(&samples[i]))
+	}
+	if len(values) < 3 || last.Sub(first) < window/2 {
+		return 0, false
+	}
+
+	fit, ok := leastSquares(linearRows(times), values)
+	if !ok {
+		return 0, false
+	}
+	return math.Round(fit[1]*100) / 100, true
+}
+
+func linearRows(x []float64) [][]float64 {
+	rows := make([][]float64, len(x))
+	for i, v := range x {
+		rows[i] = []float64{1, v}
+	}
+	return rows
+}
+
+// deriveTrends computes the slopes of the sky minus ambient temperature,
+// humidity and dew point depression over the trend window, the time until
+// dew forms at the current rate, and an overall trend label
+func deriveTrends(data *WeatherData, now time.Time) {
+	window, _ := time.ParseDuration(config.TrendWindow)
+	samples := append(history.query(now.Add(-window), now), *data)
+	updated := SensorInfo{Source: "derived", Updated: now}
+
+	skyDelta := func(s *WeatherData) float64 { return s.SkyTemperature - s.AmbientTemperature }
+	humidity := func(s *WeatherData) float64 { return s.Humidity }
+	depression := func(s *WeatherData) float64 { return s.AmbientTemperature - s.DewPoint }
+
+	skySlope, okSky := trendSlope(samples, window, skyDelta, "skyTemperature", "ambientTemperature")
+	if okSky {
+		data.SkyAmbientDeltaTrend = skySlope
+		data.Sensors["skyAmbientDeltaTrend"] = updated
+	}
+	humiditySlope, okHumidity := trendSlope(samples, window, humidity, "humidity")
+	if okHumidity {
+		data.HumidityTrend = humiditySlope
+		data.Sensors["humidityTrend"] = updated
+	}
+	depressionSlope, okDepression := trendSlope(samples, window, depression, "ambientTemperature", "dewPoint")
+	if okDepression {
+		data.DewPointDepressionTrend = depressionSlope
+		data.Sensors["dewPointDepressionTrend"] = updated
+
+		// Only meaningful while the depression is shrinking
+		if current := depression(data); current <= 0 {
+			data.TimeToDew = 0
+			data.Sensors["timeToDew"] = updated
+		} else if depressionSlope < 0 {
+			data.TimeToDew = math.Round(current / -depressionSlope * 60)
+			data.Sensors["timeToDew"] = updated
+		}
+	}
+
+	if !okSky {
+		return
+	}
+	_, dewSoon := data.Sensors["timeToDew"]
+	dewSoon = dewSoon && data.TimeToDew < trendDewWarning
+	switch {
+	case skySlope > trendSkyDeltaSlope || dewSoon || (okHumidity && humiditySlope > trendHumiditySlope):
+		data.Trend = trendDeteriorating
+	case skySlope < -trendSkyDeltaSlope:
+		data.Trend = trendClearing
+	default:
+		data.Trend = trendSteady
+	}
+	data.Sensors["trend"] = updated
+}
+
+// reopenPending is published by watchSafety while the Reopen rule holds the
+// verdict unsafe, for currentSafety to apply the same hold
+var (
+	reopenPending = true
+	reopenMutex   sync.Mutex
+)
+
+func isReopenPending() bool {
+	reopenMutex.Lock()
+	defer reopenMutex.Unlock()
+	return reopenPending
+}
+
+func setReopenPending(pending bool) {
+	reopenMutex.Lock()
+	defer reopenMutex.Unlock()
+	reopenPending = pending
+}
+
+// holdReopen applies the Reopen rule to an otherwise safe verdict while
+// reopening is pending, returning a reason while conditions must stay unsafe
+func holdReopen(data WeatherData, now time.Time, pending bool) string {
+	reopen := config.Safety.Reopen
+	if reopen.Delay == "" || !pending {
+		return ""
+	}
+	delay, _ := time.ParseDuration(reopen.Delay)
+	if trendHeld(data, now, delay, reopen.Trends) {
+		return ""
+	}
+	return fmt.Sprintf("Waiting for a %s trend for %s before reopening", strings.ToLower(strings.Join(reopen.Trends, " or ")), reopen.Delay)
+}
+
+// trendHeld reports whether the trend has been one of trends since now-delay
+func trendHeld(data WeatherData, now time.Time, delay time.Duration, trends []string) bool {
+	if !containsString(trends, data.Trend) {
+		return false
+	}
+	maxAge, _ := time.ParseDuration(config.Safety.MaxDataAge)
+	cutoff := now.Add(-delay)
+	held := false
+	for _, sample := range history.query(cutoff.Add(-maxAge), now) {
+		allowed := containsString(trends, sample.Trend)
+		// The trend can change without a new reading, so it is timed from
+		// when it was derived
+		derived := sample.Date
+		if info, ok := sample.Sensors["trend"]; ok {
+			derived = info.Updated
+		}
+		if !derived.After(cutoff) {
+			// The last sample before the cutoff gives the trend at the cutoff
+			held = allowed
+		} else if !allowed {
+			return false
+		}
+	}
+	return held
+}
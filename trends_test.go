@@ -0,0 +1,186 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateTrendConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []SourceConfig
+		reopen  ReopenConfig
+		wantErr bool
+	}{
+		{"no reopen delay", []SourceConfig{{Type: "sqm"}}, ReopenConfig{}, false},
+		{"boltwood source", []SourceConfig{{Type: "boltwood"}}, ReopenConfig{Delay: "20m"}, false},
+		{"json source", []SourceConfig{{Type: "sqm"}, {Type: "json"}}, ReopenConfig{Delay: "20m"}, false},
+		{"no sky source", []SourceConfig{{Type: "sqm"}}, ReopenConfig{Delay: "20m"}, true},
+		{"invalid delay", []SourceConfig{{Type: "boltwood"}}, ReopenConfig{Delay: "soon"}, true},
+		{"unknown trend", []SourceConfig{{Type: "boltwood"}}, ReopenConfig{Delay: "20m", Trends: []string{"Improving"}}, true},
+	}
+	for _, tt := range tests {
+		config = Config{Sources: tt.sources, Safety: SafetyConfig{Reopen: tt.reopen}}
+		if err := validateTrendConfig(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateTrendConfig() = %v", tt.name, err)
+		}
+	}
+
+	config = Config{Sources: []SourceConfig{{Type: "boltwood"}}, Safety: SafetyConfig{Reopen: ReopenConfig{Delay: "20m"}}}
+	if err := validateTrendConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(config.Safety.Reopen.Trends, ","); got != "Clearing,Steady" {
+		t.Errorf("default Reopen.Trends = %s, want Clearing,Steady", got)
+	}
+}
+
+func TestDeriveTrends(t *testing.T) {
+	now := time.Now()
+	sample := func(date time.Time, delta float64) WeatherData {
+		return WeatherData{Date: date, SkyTemperature: delta - 10, AmbientTemperature: -10, Sensors: map[string]SensorInfo{
+			"skyTemperature":     {Updated: date},
+			"ambientTemperature": {Updated: date},
+		}}
+	}
+
+	tests := []struct {
+		name      string
+		perHour   float64 // change of sky minus ambient temperature
+		samples   int
+		wantTrend string
+	}{
+		{"clearing", -6, 7, "Clearing"},
+		{"steady", 1, 7, "Steady"},
+		{"deteriorating", 6, 7, "Deteriorating"},
+		{"too little history", -6, 2, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config = Config{TrendWindow: "30m"}
+			history = newWeatherHistory(100, time.Hour)
+			for i := tt.samples; i > 0; i-- {
+				minutes := float64(i * 5)
+				history.add(sample(now.Add(-time.Duration(i)*5*time.Minute), -20-tt.perHour*minutes/60))
+			}
+
+			data := sample(now, -20)
+			deriveTrends(&data, now)
+			if data.Trend != tt.wantTrend {
+				t.Errorf("trend = %q (sky delta slope %v), want %q", data.Trend, data.SkyAmbientDeltaTrend, tt.wantTrend)
+			}
+			if _, ok := data.Sensors["trend"]; ok != (tt.wantTrend != "") {
+				t.Errorf("trend sensor present = %v", ok)
+			}
+		})
+	}
+}
+
+func TestReopenHold(t *testing.T) {
+	now := time.Now()
+	config = Config{
+		Sources: []SourceConfig{{Type: "boltwood"}},
+		Safety: SafetyConfig{
+			UnsafeConditions: map[string][]string{},
+			MaxDataAge:       "5m",
+			Reopen:           ReopenConfig{Delay: "10m"},
+		},
+	}
+	if err := validateTrendConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	trendHistory := func(trends ...string) {
+		history = newWeatherHistory(100, time.Hour)
+		for i, trend := range trends {
+			date := now.Add(time.Duration(i-len(trends)) * 2 * time.Minute)
+			history.add(WeatherData{Date: date, Trend: trend, Sensors: map[string]SensorInfo{"trend": {Updated: date}}})
+		}
+	}
+	steady := []string{"Steady", "Steady", "Steady", "Steady", "Steady", "Steady", "Steady"}
+
+	tests := []struct {
+		name     string
+		pending  bool
+		history  []string
+		trend    string
+		wantSafe bool
+	}{
+		{"not pending", false, nil, "", true},
+		{"no history yet", true, nil, "Steady", false},
+		{"steady clear sky after rain", true, steady, "Steady", true},
+		{"clearing then steady", true, []string{"Clearing", "Clearing", "Clearing", "Steady", "Steady", "Steady", "Steady"}, "Steady", true},
+		{"deteriorated within the delay", true, []string{"Steady", "Steady", "Steady", "Deteriorating", "Steady", "Steady", "Steady"}, "Steady", false},
+		{"deteriorating now", true, steady, "Deteriorating", false},
+		{"trend unknown", true, steady, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trendHistory(tt.history...)
+			setReopenPending(true)
+			data := WeatherData{Date: now, Trend: tt.trend, Sensors: map[string]SensorInfo{}}
+
+			verdict := evaluateSafety(data, now, tt.pending)
+			if verdict.Safe != tt.wantSafe {
+				t.Errorf("safe = %v (%v), want %v", verdict.Safe, verdict.Reasons, tt.wantSafe)
+			}
+			// The evaluation is repeatable and leaves the published state alone
+			if again := evaluateSafety(data, now, tt.pending); again.Safe != verdict.Safe {
+				t.Errorf("second evaluation safe = %v", again.Safe)
+			}
+			if !isReopenPending() {
+				t.Error("evaluateSafety changed the pending reopen state")
+			}
+		})
+	}
+
+	// Other reasons are reported without the reopen hold
+	config.Safety.UnsafeFlags = []string{"rainFlag"}
+	trendHistory()
+	data := WeatherData{Date: now, RainFlag: 1, Sensors: map[string]SensorInfo{"rainFlag": {Updated: now}}}
+	if verdict := evaluateSafety(data, now, true); len(verdict.Reasons) != 1 || verdict.Reasons[0] != "rainFlag is set" {
+		t.Errorf("reasons = %q, want only the rain flag", verdict.Reasons)
+	}
+}
+
+func TestTrendHeldWithoutNewReadings(t *testing.T) {
+	now := time.Now()
+	config = Config{Safety: SafetyConfig{MaxDataAge: "5m"}}
+	read := now.Add(-12 * time.Minute)
+
+	tests := []struct {
+		name   string
+		steady time.Duration // how long ago the trend turned steady
+		want   bool
+	}{
+		{"steady for longer than the delay", 11 * time.Minute, true},
+		{"steady for less than the delay", 8 * time.Minute, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The trend changes while the source sends nothing new
+			history = newWeatherHistory(10, time.Hour)
+			for _, sample := range []struct {
+				trend   string
+				derived time.Time
+			}{
+				{"Deteriorating", read},
+				{"Steady", now.Add(-tt.steady)},
+			} {
+				history.add(WeatherData{Date: read, Trend: sample.trend, Sensors: map[string]SensorInfo{
+					"skyTemperature": {Source: "roof", Updated: read},
+					"trend":          {Source: "derived", Updated: sample.derived},
+				}})
+			}
+			if history.size() != 2 {
+				t.Fatalf("%d samples, want the trend change recorded", history.size())
+			}
+
+			data := WeatherData{Date: read, Trend: "Steady"}
+			if got := trendHeld(data, now, 10*time.Minute, []string{"Steady"}); got != tt.want {
+				t.Errorf("trendHeld = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,25 +27,178 @@ type WeatherData struct {
 	DewHeaterPercentage float64   `json:"dewHeaterPercentage"`
 	RainFlag            int       `json:"rainFlag"`
 	WetFlag             int       `json:"wetFlag"`
+	RoofCloseFlag       int       `json:"roofCloseFlag"`
 	CloudCondition      string    `json:"cloudCondition"`
 	WindCondition       string    `json:"windCondition"`
 	RainCondition       string    `json:"rainCondition"`
 	DarknessCondition   string    `json:"darknessCondition"`
 	AlertStatus         string    `json:"alertStatus"`
+	Pressure            float64   `json:"pressure"`
+	SkyQuality          float64   `json:"skyQuality"`
+
+	// Cloud cover percentage estimated from the sky and ambient temperatures
+	CloudCover float64 `json:"cloudCover"`
+
+	// Quantities calculated from the ambient temperature, humidity and wind
+	CalculatedDewPoint float64 `json:"calculatedDewPoint"`
+	DewPointDepression float64 `json:"dewPointDepression"`
+	AbsoluteHumidity   float64 `json:"absoluteHumidity"`
+	WindChill          float64 `json:"windChill"`
+	DewRisk            string  `json:"dewRisk"`
+	DewPointMismatch   int     `json:"dewPointMismatch"`
+
+	// Sun and moon for the configured site
+	SunAltitude       float64 `json:"sunAltitude"`
+	MoonAltitude      float64 `json:"moonAltitude"`
+	MoonIllumination  float64 `json:"moonIllumination"`
+	DaylightCondition string  `json:"daylightCondition"`
+
+	// Trends over the trend window, per hour, with the minutes until dew
+	// forms at the current rate and a clearing, steady or deteriorating label
+	SkyAmbientDeltaTrend    float64 `json:"skyAmbientDeltaTrend"`
+	HumidityTrend           float64 `json:"humidityTrend"`
+	DewPointDepressionTrend float64 `json:"dewPointDepressionTrend"`
+	TimeToDew               float64 `json:"timeToDew"`
+	Trend                   string  `json:"trend"`
+
+	// Safety verdict derived from the fused data
+	Safe          bool     `json:"safe"`
+	UnsafeReasons []string `json:"unsafeReasons,omitempty"`
+
+	// Sensors records which source supplied each field and when
+	Sensors map[string]SensorInfo `json:"sensors,omitempty"`
+
+	// Raw holds the unfiltered values of fields changed by the outlier
+	// filters, and Rejected the fields whose latest reading was rejected
+	Raw      map[string]float64 `json:"raw,omitempty"`
+	Rejected []string           `json:"rejected,omitempty"`
 }
 
-var weatherData WeatherData
+// SensorInfo is the origin of a single fused WeatherData field
+type SensorInfo struct {
+	Source  string    `json:"source"`
+	Updated time.Time `json:"updated"`
+}
+
+var (
+	weatherData  WeatherData
+	weatherMutex sync.RWMutex
+)
+
+// getWeatherData returns the current fused weather data. The Sensors map is
+// never modified after publication, so the copy is safe to read.
+func getWeatherData() WeatherData {
+	weatherMutex.RLock()
+	defer weatherMutex.RUnlock()
+	return weatherData
+}
+
+func setWeatherData(data WeatherData) {
+	weatherMutex.Lock()
+	weatherData = data
+	weatherMutex.Unlock()
+
+	for _, listener := range weatherListeners {
+		listener(data)
+	}
+}
+
+// weatherListeners are called with every newly published WeatherData. They
+// are registered at startup and run on the polling goroutine, so must not block.
+var weatherListeners []func(WeatherData)
+
+func onWeatherUpdate(listener func(WeatherData)) {
+	weatherListeners = append(weatherListeners, listener)
+}
+
+// sourceErrorListeners are called when reading or parsing a source fails
+var sourceErrorListeners []func(source string, err error)
+
+func onSourceError(listener func(source string, err error)) {
+	sourceErrorListeners = append(sourceErrorListeners, listener)
+}
+
+func reportSourceError(src *SourceConfig, err error) error {
+	for _, listener := range sourceErrorListeners {
+		listener(src.Name, err)
+	}
+	return fmt.Errorf("source %s: %v", src.Name, err)
+}
+
+// refreshRequests carries out-of-cycle poll requests to pollWeatherData.
+// Each request is a channel that receives the result of the next poll.
+var refreshRequests = make(chan chan error)
 
 func pollWeatherData() {
 	interval, _ := time.ParseDuration(config.PollingInterval)
+	var waiters []chan error
 	for {
-		data, err := readBoltwoodData(config.BoltwoodSource)
+		err := pollSources()
+		for _, waiter := range waiters {
+			waiter <- err
+		}
+		waiters = nil
+
+		select {
+		case <-time.After(interval):
+		case waiter := <-refreshRequests:
+			waiters = append(waiters, waiter)
+			// Coalesce refreshes queued behind this one into the same poll
+		coalesce:
+			for {
+				select {
+				case waiter := <-refreshRequests:
+					waiters = append(waiters, waiter)
+				default:
+					break coalesce
+				}
+			}
+		}
+	}
+}
+
+// pollSources reads every source once, returning the errors of those that failed
+func pollSources() error {
+	var errs []error
+	for i := range config.Sources {
+		src := &config.Sources[i]
+		start := time.Now()
+		err := pollSource(src)
+		recordSourcePoll(src.Name, time.Since(start), err)
 		if err != nil {
-			log.Printf("Error reading Boltwood data: %v", err)
-		} else {
-			parseAndUpdateWeatherData(data)
+			errs = append(errs, reportSourceError(src, err))
 		}
-		time.Sleep(interval)
+	}
+	return errors.Join(errs...)
+}
+
+func pollSource(src *SourceConfig) error {
+	data, err := readSourceData(src)
+	if err != nil {
+		log.Printf("Error reading %s data from source %s: %v", src.Type, src.Name, err)
+		return err
+	}
+	if err := parseAndUpdateWeatherData(src, data); err != nil {
+		log.Printf("Error parsing %s data from source %s: %v", src.Type, src.Name, err)
+		return err
+	}
+	return nil
+}
+
+// refreshWeatherData triggers an immediate poll and waits for its result
+func refreshWeatherData(ctx context.Context) error {
+	waiter := make(chan error, 1)
+	select {
+	case refreshRequests <- waiter:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-waiter:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
@@ -65,37 +222,33 @@ func readFromFile(path string) ([]byte, error) {
 	return os.ReadFile(path)
 }
 
-func parseAndUpdateWeatherData(data []byte) {
-	lines := strings.Split(string(data), "\n")
+// parseBoltwoodData parses a Boltwood II one-line data file
+func parseBoltwoodData(data []byte) (WeatherData, error) {
+	var newWeatherData WeatherData
+
+	lines := strings.Split(strings.TrimRight(string(data), "\r\n"), "\n")
 	if len(lines) != 1 {
-		log.Printf("Invalid Boltwood data format")
-		return
+		return newWeatherData, fmt.Errorf("invalid Boltwood data format")
 	}
 
 	fields := strings.Fields(lines[0])
 	if len(fields) < 21 {
-		log.Printf("Insufficient fields in Boltwood data: got %d, expected 21", len(fields))
-		return
+		return newWeatherData, fmt.Errorf("insufficient fields in Boltwood data: got %d, expected 21", len(fields))
 	}
 
-	var err error
-	var newWeatherData WeatherData
-
 	// Parse date and time (fields 0 and 1)
 	dateStr := fields[0] + " " + fields[1]
 
 	// Load the configured timezone
 	loc, err := time.LoadLocation(config.Timezone)
 	if err != nil {
-		log.Printf("Error loading timezone %s: %v", config.Timezone, err)
-		return
+		return newWeatherData, fmt.Errorf("error loading timezone %s: %v", config.Timezone, err)
 	}
 
 	// Parse the date string in the configured timezone
 	newWeatherData.Date, err = time.ParseInLocation("2006-01-02 15:04:05.00", dateStr, loc)
 	if err != nil {
-		log.Printf("Error parsing date: %v", err)
-		return
+		return newWeatherData, fmt.Errorf("error parsing date: %v", err)
 	}
 
 	// Convert the time to UTC for storage
@@ -133,6 +286,8 @@ func parseAndUpdateWeatherData(data []byte) {
 	darknessVal, _ := strconv.Atoi(fields[18])
 	newWeatherData.DarknessCondition = parseDarknessCondition(darknessVal)
 
+	newWeatherData.RoofCloseFlag, _ = strconv.Atoi(fields[19])
+
 	alertVal, _ := strconv.Atoi(fields[20])
 	newWeatherData.AlertStatus = parseAlertStatus(alertVal)
 
@@ -140,9 +295,40 @@ func parseAndUpdateWeatherData(data []byte) {
 	newWeatherData.TemperatureScale = "C"
 	newWeatherData.WindSpeedScale = "m/s"
 
-	// Update the global weatherData
-	weatherData = newWeatherData
-	log.Printf("Weather data updated: %+v", weatherData)
+	return newWeatherData, nil
+}
+
+// parseAndUpdateWeatherData parses raw data read from a source, records it
+// as that source's latest reading and republishes the fused weather data.
+func parseAndUpdateWeatherData(src *SourceConfig, data []byte) error {
+	reading, err := parseSourceData(src, data)
+	if err != nil {
+		return err
+	}
+	reading.Received = time.Now()
+	filterReading(src.Name, &reading)
+
+	sourceMutex.Lock()
+	sourceReadings[src.Name] = reading
+	fused := fuseWeatherData(reading.Received)
+	sourceMutex.Unlock()
+	deriveWeatherData(&fused, reading.Received)
+
+	setWeatherData(fused)
+	log.Printf("Weather data updated from %s: %+v", src.Name, fused)
+	return nil
+}
+
+// deriveWeatherData computes the fields calculated from the fused measurements
+func deriveWeatherData(data *WeatherData, now time.Time) {
+	deriveHumidityMetrics(data)
+	deriveCloudCover(data)
+	deriveEphemeris(data, now)
+	deriveTrends(data, now)
+
+	verdict := evaluateSafety(*data, now, isReopenPending())
+	data.Safe, data.UnsafeReasons = verdict.Safe, verdict.Reasons
+	data.Sensors["safe"] = SensorInfo{Source: "derived", Updated: now}
 }
 
 func convertTemperature(tempStr, scale string) float64 {
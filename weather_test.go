@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingSource serves JSON source data, holding each poll until released
+type blockingSource struct {
+	mu      sync.Mutex
+	polls   int
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.polls++
+	poll := s.polls
+	s.mu.Unlock()
+
+	s.started <- struct{}{}
+	<-s.release
+	fmt.Fprintf(w, `{"humidity": %d}`, 40+poll)
+}
+
+func (s *blockingSource) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.polls
+}
+
+// The poll loop runs forever, so one is shared by every run of the test
+var pollLoopOnce sync.Once
+
+func TestRefreshWeatherDataCoalesces(t *testing.T) {
+	source := &blockingSource{started: make(chan struct{}), release: make(chan struct{})}
+	server := httptest.NewServer(source)
+	defer server.Close()
+
+	config = Config{PollingInterval: "1h", Sources: []SourceConfig{{Name: "json", Type: "json", URL: server.URL}}}
+	history = newWeatherHistory(10, time.Hour)
+	pollLoopOnce.Do(func() {
+		go pollWeatherData()
+		// The initial poll
+		<-source.started
+		source.release <- struct{}{}
+	})
+	polls := source.count()
+
+	refresh := func() chan error {
+		result := make(chan error, 1)
+		go func() { result <- refreshWeatherData(context.Background()) }()
+		return result
+	}
+
+	first := refresh()
+	<-source.started
+
+	// Both queue behind the poll in progress and share the next one
+	second, third := refresh(), refresh()
+	time.Sleep(50 * time.Millisecond)
+	source.release <- struct{}{}
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+
+	<-source.started
+	source.release <- struct{}{}
+	for _, result := range []chan error{second, third} {
+		select {
+		case err := <-result:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("refresh did not return")
+		}
+	}
+	if polls := source.count() - polls; polls != 2 {
+		t.Errorf("%d polls, want 2 (the first refresh, then both queued refreshes together)", polls)
+	}
+	if humidity := getWeatherData().Humidity; humidity != float64(40+source.count()) {
+		t.Errorf("humidity = %v, want %d from the last poll", humidity, 40+source.count())
+	}
+
+	// A refresh gives up when its request is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- refreshWeatherData(ctx) }()
+	<-source.started
+	cancel()
+	if err := <-result; err != context.Canceled {
+		t.Errorf("cancelled refresh returned %v", err)
+	}
+	source.release <- struct{}{}
+
+	// Let the abandoned poll finish before other tests change the config
+	last := refresh()
+	<-source.started
+	source.release <- struct{}{}
+	<-last
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
+	"os"
 )
 
 func main() {
@@ -13,6 +14,41 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// Offline export of the on-disk history
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExportCommand(os.Args[2:]); err != nil {
+			log.Fatalf("Export failed: %v", err)
+		}
+		return
+	}
+
+	// Fit the cloud cover model to clear nights in the on-disk history
+	if len(os.Args) > 1 && os.Args[1] == "calibrate-clouds" {
+		if err := runCalibrateCloudsCommand(os.Args[2:]); err != nil {
+			log.Fatalf("Calibration failed: %v", err)
+		}
+		return
+	}
+
+	// Keep recent samples for the history API, backed by disk if configured
+	initHistory()
+	if err := initHistoryStore(); err != nil {
+		log.Fatalf("Failed to open history store: %v", err)
+	}
+
+	// Publish updates to stream clients, exporters, notifiers and the roof, then
+	// start tracking the safety verdict once all its listeners are registered
+	initStream()
+	initExporters()
+	initMQTT()
+	initBoltwoodFile()
+	initNotifiers()
+	initDome()
+	startAutoClose()
+	initSwitches()
+	initNights()
+	startSafetyWatch()
+
 	// Start weather data polling and register the driver with alpaca
 	go pollWeatherData()
 	go handleAlpacaDiscovery()